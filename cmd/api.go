@@ -0,0 +1,340 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+const defaultBaseURL = "https://api.okareo.com"
+
+// EndpointConfig holds the connection settings for self-hosted Okareo deployments.
+// Values come from config.yml and are overridden by the matching root flags and env vars.
+type EndpointConfig struct {
+	BaseURL  string `yaml:"base-url"`
+	CABundle string `yaml:"ca-bundle"`
+	Proxy    string `yaml:"proxy"`
+}
+
+// configEndpoint is populated from config.yml by commands that load one.
+var configEndpoint EndpointConfig
+
+// setConfigEndpoint applies the endpoint settings of the config.yml in workspace.
+func setConfigEndpoint(endpoint EndpointConfig, workspace string) {
+	configEndpoint = EndpointConfig{
+		BaseURL:  tradeForEnvValue(endpoint.BaseURL),
+		CABundle: tradeForEnvValue(endpoint.CABundle),
+		Proxy:    tradeForEnvValue(endpoint.Proxy),
+	}
+	// relative to the workspace, so builds and flows that run in .okareo find it too
+	if configEndpoint.CABundle != "" {
+		if !filepath.IsAbs(configEndpoint.CABundle) {
+			configEndpoint.CABundle = filepath.Join(workspace, configEndpoint.CABundle)
+		}
+		configEndpoint.CABundle = absPath(configEndpoint.CABundle)
+	}
+}
+
+// firstSetting returns the first non-empty value of the root flag, the env var and the config value.
+func firstSetting(flag string, env string, configValue string) string {
+	if value, _ := rootCmd.PersistentFlags().GetString(flag); value != "" {
+		return value
+	}
+	if value := os.Getenv(env); value != "" {
+		return value
+	}
+	return configValue
+}
+
+// get_endpoint resolves the Okareo API base URL from --base-url, OKAREO_BASE_URL,
+// config.yml and the stored account, in that order.
+func get_endpoint() string {
+	endpoint := firstSetting("base-url", "OKAREO_BASE_URL", configEndpoint.BaseURL)
+	if endpoint == "" {
+		if creds, err := loadCredentials(); err == nil {
+			if account, ok := creds.Accounts[selectedAccount(creds)]; ok {
+				endpoint = account.BaseURL
+			}
+		}
+	}
+	if endpoint == "" {
+		return defaultBaseURL
+	}
+	return strings.TrimSuffix(endpoint, "/")
+}
+
+func get_ca_bundle() string {
+	return firstSetting("ca-bundle", "OKAREO_CA_BUNDLE", configEndpoint.CABundle)
+}
+
+func get_proxy() string {
+	return firstSetting("proxy", "OKAREO_PROXY", configEndpoint.Proxy)
+}
+
+// newHTTPClient builds the client used for every Okareo API call. It trusts the
+// system roots plus any custom CA bundle and routes through the configured proxy,
+// falling back to the standard HTTP_PROXY/HTTPS_PROXY/NO_PROXY variables.
+func newHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if proxy := get_proxy(); proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			fmt.Fprintln(runOutput, "Error: The proxy URL '"+proxy+"' is not valid.")
+			os.Exit(1)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if ca_bundle := get_ca_bundle(); ca_bundle != "" {
+		pem, err := os.ReadFile(ca_bundle)
+		if err != nil {
+			fmt.Fprintln(runOutput, "Error: Unable to read the CA bundle '"+ca_bundle+"'.")
+			os.Exit(1)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			fmt.Fprintln(runOutput, "Error: No certificates found in the CA bundle '"+ca_bundle+"'.")
+			os.Exit(1)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &http.Client{Transport: transport}
+}
+
+// endpointEnv returns the env vars that point child processes (flow scripts, the proxy)
+// at the same Okareo deployment as the CLI. Only OKAREO_* variables are set: the generic
+// CA and proxy variables would change the trust store and routing of every connection
+// a flow makes, not just its calls to Okareo.
+func endpointEnv() []string {
+	env := []string{"OKAREO_BASE_URL=" + get_endpoint()}
+	if ca_bundle := get_ca_bundle(); ca_bundle != "" {
+		env = append(env, "OKAREO_CA_BUNDLE="+ca_bundle)
+	}
+	if proxy := get_proxy(); proxy != "" {
+		env = append(env, "OKAREO_PROXY="+proxy)
+	}
+	return env
+}
+
+// APIError is returned by doOkareoRequest for non-2xx responses.
+type APIError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	if e.Body == "" {
+		return e.Status
+	}
+	return e.Status + ": " + e.Body
+}
+
+// doOkareoRequest sends a JSON request to the Okareo API and decodes the JSON
+// response into out (when non-nil). body may be nil, a []byte or any value to marshal.
+func doOkareoRequest(api_token string, method string, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		reader = bytes.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, get_endpoint()+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("api-key", api_token)
+	if reader != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	return sendOkareoRequest(req, out)
+}
+
+// doOkareoUpload posts a multipart form with a single file part to the Okareo API.
+func doOkareoUpload(api_token string, path string, fields map[string]string, fileField string, fileName string, content []byte, out interface{}) error {
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	for key, value := range fields {
+		if err := writer.WriteField(key, value); err != nil {
+			return err
+		}
+	}
+	part, err := writer.CreateFormFile(fileField, fileName)
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(content); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, get_endpoint()+path, &form)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("api-key", api_token)
+	req.Header.Add("Content-Type", writer.FormDataContentType())
+	return sendOkareoRequest(req, out)
+}
+
+// sendOkareoRequest executes req and decodes a JSON response into out (when non-nil).
+func sendOkareoRequest(req *http.Request, out interface{}) error {
+	resp, err := newHTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	result, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return &APIError{StatusCode: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(result))}
+	}
+	if out == nil || len(result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(result, out)
+}
+
+// the number of times test runs and model lookups are retried on 429 and 5xx responses
+var api_retries int = 3
+
+// the delay before the first API retry, doubled for each one after it
+var api_retry_delay time.Duration = time.Second
+
+// doWithRetry sends req and retries the responses retryAPIResponse allows with exponential
+// backoff, honoring Retry-After. It returns the last response and how many retries were made.
+func doWithRetry(client *http.Client, req *http.Request) (*http.Response, int, error) {
+	delay := api_retry_delay
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, attempt, err
+			}
+			req.Body = body
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, attempt, err
+		}
+		if attempt >= api_retries || !retryAPIResponse(req, resp) {
+			return resp, attempt, nil
+		}
+		wait := delay
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			wait = time.Duration(seconds) * time.Second
+		}
+		resp.Body.Close()
+		fmt.Fprintf(runOutput, "Warning: %s %s returned %s. Retrying in %s (retry %d of %d).\n", req.Method, req.URL.Path, resp.Status, wait, attempt+1, api_retries)
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, attempt, req.Context().Err()
+		}
+		delay *= 2
+	}
+}
+
+// retryAPIResponse reports whether a request may be sent again. GET requests retry 429
+// and 5xx responses. Other requests, such as creating a test run, could be applied twice,
+// so they only retry responses that say the request was not processed: 429, and 503 with
+// Retry-After.
+func retryAPIResponse(req *http.Request, resp *http.Response) bool {
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return true
+	case req.Method == http.MethodGet || req.Method == http.MethodHead:
+		return resp.StatusCode >= http.StatusInternalServerError
+	}
+	return resp.StatusCode == http.StatusServiceUnavailable && resp.Header.Get("Retry-After") != ""
+}
+
+// loadCommandConfig reads the workspace config.yml for commands that don't run flows,
+// so that its api-key, base-url, ca-bundle and proxy apply to them as they do to
+// okareo run. The workspace is --workspace or the nearest folder with a .okareo
+// directory, and --config overrides the file where a command has it. The working
+// directory doesn't change, so path arguments stay relative to it.
+func loadCommandConfig(cmd *cobra.Command) Config {
+	workspace, _ := cmd.Flags().GetString("workspace")
+	if workspace == "" {
+		workspace = findWorkspaceUp()
+	}
+	if workspace == "" {
+		workspace = "."
+	}
+	config_file := filepath.Join(workspace, ".okareo", "config.yml")
+	if flag := cmd.Flags().Lookup("config"); flag != nil && flag.Changed {
+		config_file = flag.Value.String()
+	}
+	config, err := readWorkspaceConfig(config_file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	setConfigEndpoint(config.EndpointConfig, workspace)
+	return config
+}
+
+// readWorkspaceConfig reads config.yml for commands that only need its API key and
+// endpoint. A missing file is an empty config.
+func readWorkspaceConfig(config_file string) (Config, error) {
+	config := Config{}
+	data, err := os.ReadFile(config_file)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, fmt.Errorf("unable to read the config file: %v", err)
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("invalid config file %s: %v", config_file, err)
+	}
+	return config, nil
+}
+
+// requireAPIKey resolves the API key and endpoint for commands that don't run flows,
+// from the workspace config.yml, OKAREO_API_KEY or the stored key, and exits with a
+// hint when none is available.
+func requireAPIKey(cmd *cobra.Command) string {
+	config := loadCommandConfig(cmd)
+	apiKey, _ := resolveAPIKey(config.APIKey)
+	if apiKey == "" {
+		fmt.Println("Error: No Okareo API key found. Run 'okareo login' or set OKAREO_API_KEY.")
+		os.Exit(1)
+	}
+	return apiKey
+}
@@ -20,6 +20,10 @@ var cleanCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		isDebug, _ := cmd.Flags().GetBool("debug")
 		force, _ := cmd.Flags().GetBool("force")
+		if err := enterWorkspace(cmd, true); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
 
 		var config_file_path string = "./.okareo/config.yml"
 		var pkg_file_path = "./.okareo/package.json"
@@ -27,6 +31,7 @@ var cleanCmd = &cobra.Command{
 		var tsconfig_file_path = "./.okareo/tsconfig.json"
 		var install_file_path = "./.okareo/install.sh"
 		var node_modules_dir_path = "./.okareo/node_modules"
+		var venv_dir_path = python_venv_dir
 		var dist_dir_path = "./.okareo/dist"
 		var reports_dir_path = "./.okareo/reports"
 
@@ -99,6 +104,15 @@ var cleanCmd = &cobra.Command{
 				fmt.Println("Removed", node_modules_dir_path)
 			}
 		}
+		if dirExists(venv_dir_path) {
+			v_err := os.RemoveAll(venv_dir_path)
+			if v_err != nil {
+				fmt.Println(v_err)
+			}
+			if isDebug {
+				fmt.Println("Removed", venv_dir_path)
+			}
+		}
 		if dirExists(dist_dir_path) {
 			d_err := os.RemoveAll(dist_dir_path)
 			if d_err != nil {
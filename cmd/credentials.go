@@ -0,0 +1,136 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+const defaultAccountName = "default"
+
+type Account struct {
+	APIKey    string    `yaml:"api-key"`
+	BaseURL   string    `yaml:"base-url,omitempty"`
+	ProjectID string    `yaml:"project-id,omitempty"`
+	ModelKeys ModelKeys `yaml:"model-keys,omitempty"` // from `okareo login --model-key`
+}
+
+// Credentials is the per-user credential store written by `okareo login`.
+type Credentials struct {
+	Current  string              `yaml:"current"`
+	Accounts map[string]*Account `yaml:"accounts"`
+}
+
+// credentialsFilePath returns the location of the per-user credential store.
+// OKAREO_CREDENTIALS_FILE overrides the default of <user config dir>/okareo/credentials.yml.
+func credentialsFilePath() (string, error) {
+	if path := os.Getenv("OKAREO_CREDENTIALS_FILE"); path != "" {
+		return path, nil
+	}
+	config_dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(config_dir, "okareo", "credentials.yml"), nil
+}
+
+func loadCredentials() (*Credentials, error) {
+	creds := &Credentials{Accounts: map[string]*Account{}}
+	path, err := credentialsFilePath()
+	if err != nil {
+		return creds, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return creds, nil
+	}
+	if err != nil {
+		return creds, err
+	}
+	if err := yaml.Unmarshal(data, creds); err != nil {
+		return creds, err
+	}
+	if creds.Accounts == nil {
+		creds.Accounts = map[string]*Account{}
+	}
+	return creds, nil
+}
+
+func saveCredentials(creds *Credentials) error {
+	path, err := credentialsFilePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	// WriteFile only applies the mode on create, so tighten pre-existing files too
+	return os.Chmod(path, 0600)
+}
+
+// accountNames returns the stored account names in a stable order.
+func (c *Credentials) accountNames() []string {
+	names := make([]string, 0, len(c.Accounts))
+	for name := range c.Accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// selectedAccount returns the account requested via --account or OKAREO_ACCOUNT,
+// falling back to the account last used with `okareo login`.
+func selectedAccount(creds *Credentials) string {
+	if name, _ := rootCmd.PersistentFlags().GetString("account"); name != "" {
+		return name
+	}
+	if name := os.Getenv("OKAREO_ACCOUNT"); name != "" {
+		return name
+	}
+	if creds != nil && creds.Current != "" {
+		return creds.Current
+	}
+	return defaultAccountName
+}
+
+// resolveAPIKey picks the Okareo API key in order of precedence: the value from
+// config.yml (after ${ENV} interpolation), OKAREO_API_KEY, then the credential store.
+// The second return value describes where the key was found.
+func resolveAPIKey(configValue string) (string, string) {
+	if key := tradeForEnvValue(configValue); key != "" {
+		return key, "config"
+	}
+	if key := os.Getenv("OKAREO_API_KEY"); key != "" {
+		return key, "OKAREO_API_KEY"
+	}
+	creds, err := loadCredentials()
+	if err != nil {
+		return "", ""
+	}
+	name := selectedAccount(creds)
+	if account, ok := creds.Accounts[name]; ok && account.APIKey != "" {
+		return account.APIKey, "account '" + name + "'"
+	}
+	return "", ""
+}
+
+// maskSecret keeps just enough of a secret to recognize it in output.
+func maskSecret(secret string) string {
+	if len(secret) <= 8 {
+		return "********"
+	}
+	return secret[:4] + "..." + secret[len(secret)-4:]
+}
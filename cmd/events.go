@@ -0,0 +1,163 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// the kinds of events `okareo run --output json|ndjson` emits
+const (
+	eventRunStarted   = "run_started"
+	eventFlowStarted  = "flow_started"
+	eventStdout       = "stdout"
+	eventFlowFinished = "flow_finished"
+	eventRunFinished  = "run_finished"
+)
+
+// runOutput is where `okareo run` prints for people. With --output json|ndjson it is
+// stderr, so stdout carries only the events.
+var runOutput io.Writer = os.Stdout
+
+// RunEvent is one structured event of `okareo run`. Fields that don't apply are omitted.
+type RunEvent struct {
+	Event      string                 `json:"event"`
+	Time       string                 `json:"time"`
+	Run        string                 `json:"run"`
+	Workspace  string                 `json:"workspace,omitempty"`
+	Flow       string                 `json:"flow,omitempty"`
+	Runner     string                 `json:"runner,omitempty"`
+	Line       string                 `json:"line,omitempty"`
+	Status     string                 `json:"status,omitempty"`
+	DurationMs int64                  `json:"duration_ms,omitempty"`
+	Retries    int                    `json:"retries,omitempty"`
+	TestRuns   []FlowTestRun          `json:"test_runs,omitempty"`
+	Metrics    map[string]interface{} `json:"metrics,omitempty"`
+	Assertions []FlowAssertion        `json:"assertions,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	Summary    *RunSummary            `json:"summary,omitempty"`
+}
+
+// RunSummary counts the flows of a run by status.
+type RunSummary struct {
+	Total     int `json:"total"`
+	Passed    int `json:"passed"`
+	Failed    int `json:"failed"`
+	TimedOut  int `json:"timed_out"`
+	Cancelled int `json:"cancelled"`
+	Retries   int `json:"retries"`
+}
+
+// RunReporter turns the progress of `okareo run` into structured events. With the
+// default text output it only echoes flow stdout.
+type RunReporter struct {
+	Format    string // text, json or ndjson
+	RunName   string
+	Workspace string // set by --all-workspaces
+	Hold      bool   // keep --output json events for Flush instead of writing them at RunFinished
+	out       io.Writer
+	events    []RunEvent
+}
+
+func newRunReporter(format string, out io.Writer) (*RunReporter, error) {
+	format = strings.ToLower(format)
+	switch format {
+	case "", "text":
+		format = "text"
+	case "json", "ndjson":
+	default:
+		return nil, fmt.Errorf("unknown output format '%s'. Use text, json or ndjson", format)
+	}
+	return &RunReporter{Format: format, out: out}, nil
+}
+
+func (r *RunReporter) structured() bool {
+	return r.Format != "text"
+}
+
+// emit writes an ndjson event right away; json events are written together by RunFinished.
+func (r *RunReporter) emit(event RunEvent) {
+	if !r.structured() {
+		return
+	}
+	event.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	event.Run = r.RunName
+	event.Workspace = r.Workspace
+	if r.Format == "json" {
+		r.events = append(r.events, event)
+		return
+	}
+	data, _ := json.Marshal(event)
+	fmt.Fprintln(r.out, string(data))
+}
+
+func (r *RunReporter) RunStarted() {
+	r.emit(RunEvent{Event: eventRunStarted})
+}
+
+func (r *RunReporter) FlowStarted(flow string, runner string) {
+	r.emit(RunEvent{Event: eventFlowStarted, Flow: flow, Runner: runner})
+}
+
+// Line passes on a line a flow wrote to stdout.
+func (r *RunReporter) Line(flow string, line string) {
+	if !r.structured() {
+		fmt.Fprint(runOutput, line)
+		return
+	}
+	r.emit(RunEvent{Event: eventStdout, Flow: flow, Line: strings.TrimRight(line, "\r\n")})
+}
+
+func (r *RunReporter) FlowFinished(result FlowResult) {
+	r.emit(RunEvent{
+		Event:      eventFlowFinished,
+		Flow:       result.Name,
+		Runner:     result.Runner,
+		Status:     result.Status,
+		DurationMs: result.Duration.Milliseconds(),
+		Retries:    result.Retries,
+		TestRuns:   result.TestRuns,
+		Metrics:    result.Metrics,
+		Assertions: result.Assertions,
+		Error:      result.Error,
+	})
+}
+
+// RunFinished emits the run summary and, for --output json, every event of the run
+// as one JSON array unless the reporter holds them.
+func (r *RunReporter) RunFinished(results []FlowResult) {
+	summary := summarizeResults(results)
+	r.emit(RunEvent{Event: eventRunFinished, Summary: summary})
+	if !r.Hold {
+		r.Flush()
+	}
+}
+
+// Fatal prints an error that ends the run early and exits 1. Structured output still
+// ends with a run_finished event, which carries the error.
+func (r *RunReporter) Fatal(message string, err error) {
+	if err != nil {
+		fmt.Fprintln(runOutput, message, err)
+		message = message + " " + err.Error()
+	} else {
+		fmt.Fprintln(runOutput, message)
+	}
+	r.emit(RunEvent{Event: eventRunFinished, Error: strings.TrimPrefix(message, "Error: ")})
+	r.Flush()
+	os.Exit(1)
+}
+
+// Flush writes the --output json events collected so far as one JSON array.
+func (r *RunReporter) Flush() {
+	if r.Format == "json" {
+		data, _ := json.MarshalIndent(r.events, "", "  ")
+		fmt.Fprintln(r.out, string(data))
+		r.events = nil
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// flowLog keeps a timestamped copy of a flow's stdout and stderr in <reports>/<flow>.log
+// so failures in CI can be diagnosed from the reports artifacts.
+type flowLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// openFlowLog creates the log of a flow. Without a reports directory it returns a
+// flowLog that discards everything.
+func openFlowLog(reports_dir_path string, flow FlowFile) (*flowLog, error) {
+	if reports_dir_path == "" {
+		return &flowLog{}, nil
+	}
+	log_path := filepath.Join(reports_dir_path, filepath.FromSlash(flow.Name)+".log")
+	if err := os.MkdirAll(filepath.Dir(log_path), 0755); err != nil {
+		return &flowLog{}, err
+	}
+	file, err := os.Create(log_path)
+	if err != nil {
+		return &flowLog{}, err
+	}
+	return &flowLog{file: file}, nil
+}
+
+// Write records one line from stream (stdout, stderr or okareo for the CLI's own notes).
+func (l *flowLog) Write(stream string, line string) {
+	if l.file == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	timestamp := time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+	fmt.Fprintf(l.file, "%s %-6s %s\n", timestamp, stream, strings.TrimRight(line, "\r\n"))
+}
+
+func (l *flowLog) Close() {
+	if l.file != nil {
+		l.file.Close()
+	}
+}
+
+// lineWriter calls onLine for every complete line written to it.
+type lineWriter struct {
+	buf    []byte
+	onLine func(line string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.onLine(string(w.buf[:i+1]))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush passes on a last line that has no newline, ending it with one.
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.onLine(string(w.buf) + "\n")
+		w.buf = nil
+	}
+}
@@ -0,0 +1,506 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// FlowFile is a script flow found in .okareo/flows and the language it runs with.
+type FlowFile struct {
+	Name     string // path relative to .okareo/flows with / separators, e.g. retrieval/search.py
+	Path     string
+	Group    string // the folder of the flow under .okareo/flows, "" at the top level
+	Language string
+	Tags     []string // from an "okareo-tags:" header comment
+}
+
+// normalizeLanguage maps the names accepted in config.yml to a built-in or custom runner.
+// "auto" runs every detected language side by side; unknown names return "".
+func normalizeLanguage(language string, runners []FlowRunner) string {
+	language = strings.ToLower(language)
+	switch language {
+	case "python", "py":
+		return "python"
+	case "typescript", "ts":
+		return "typescript"
+	case "javascript", "js":
+		return "javascript"
+	case "go", "golang":
+		return "go"
+	case "auto", "mixed":
+		return "auto"
+	}
+	if findRunner(runners, language) != nil {
+		return language
+	}
+	return ""
+}
+
+// detectFlowLanguage picks the runner of a flow file from the config overrides
+// (file name globs) or else the first runner that detects it.
+func detectFlowLanguage(name string, overrides FlowGlobs[string], runners []FlowRunner) string {
+	if language, ok := overrides.Lookup(name); ok {
+		return normalizeLanguage(language, runners)
+	}
+	for _, runner := range runners {
+		if runner.Detect(name) {
+			return runner.Name()
+		}
+	}
+	return ""
+}
+
+func discoverFlowFiles(flows_folder string, overrides FlowGlobs[string], runners []FlowRunner, isDebug bool) ([]FlowFile, error) {
+	if _, err := os.Stat(flows_folder); err != nil {
+		if isDebug {
+			fmt.Fprintln(runOutput, "Debug: Flows folder not found.")
+		}
+		return nil, err
+	}
+	flows := []FlowFile{}
+	err := filepath.WalkDir(flows_folder, func(file_path string, e os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.IsDir() {
+			if file_path != flows_folder && skipFlowDir(e.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(flows_folder, file_path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		language := detectFlowLanguage(name, overrides, runners)
+		if language == "" || language == "auto" {
+			if isDebug {
+				fmt.Fprintln(runOutput, "Debug: No language detected for", name)
+			}
+			return nil
+		}
+		group := path.Dir(name)
+		if group == "." {
+			group = ""
+		}
+		flows = append(flows, FlowFile{Name: name, Path: flows_folder + name, Group: group, Language: language, Tags: readFlowTags(file_path)})
+		return nil
+	})
+	return flows, err
+}
+
+// skipFlowDir reports whether a folder under .okareo/flows holds no flows, such as
+// node_modules, __pycache__ or hidden folders.
+func skipFlowDir(name string) bool {
+	return name == "node_modules" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "__")
+}
+
+// FlowFilter selects the flows of a run with --flow and --exclude globs and --tag.
+type FlowFilter struct {
+	Include []string
+	Exclude []string
+	Tags    []string
+}
+
+// Selective reports whether the filter names specific flows or tags.
+func (f FlowFilter) Selective() bool {
+	return len(f.Include) > 0 || len(f.Tags) > 0
+}
+
+func (f FlowFilter) Match(name string, tags []string) bool {
+	if len(f.Include) > 0 && !matchAnyFlowGlob(f.Include, name) {
+		return false
+	}
+	if matchAnyFlowGlob(f.Exclude, name) {
+		return false
+	}
+	return hasAllTags(tags, f.Tags)
+}
+
+// matchAnyFlowGlob reports whether any of the globs matches the flow name (see matchFlowGlob).
+func matchAnyFlowGlob(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchFlowGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchFlowGlob matches a flow name such as retrieval/search.py against a glob.
+//   - A glob without a folder also matches the file name, so "search.py" selects
+//     retrieval/search.py.
+//   - A glob without an extension also matches the name without its extension, so
+//     "retrieval/search" selects retrieval/search.py but not retrieval/search_v2.py.
+//   - A glob that matches a folder selects every flow in it, e.g. "retrieval".
+func matchFlowGlob(pattern string, name string) bool {
+	pattern = filepath.ToSlash(pattern)
+	candidates := []string{name}
+	if !strings.Contains(pattern, "/") {
+		candidates = append(candidates, path.Base(name))
+	}
+	if path.Ext(pattern) == "" {
+		for _, candidate := range candidates {
+			candidates = append(candidates, strings.TrimSuffix(candidate, path.Ext(candidate)))
+		}
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			candidates = append(candidates, dir)
+		}
+	}
+	for _, candidate := range candidates {
+		if match, _ := path.Match(pattern, candidate); match {
+			return true
+		}
+	}
+	return false
+}
+
+// FlowGlobs maps flow globs to a setting in config.yml, such as languages: or timeouts:.
+// The globs keep their config order and the first one matching a flow wins, so
+// specific globs go before general ones.
+type FlowGlobs[T any] []FlowGlob[T]
+
+type FlowGlob[T any] struct {
+	Pattern string
+	Value   T
+}
+
+func (g *FlowGlobs[T]) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	// decode the values with their type, and the keys again in order
+	values := map[string]T{}
+	if err := unmarshal(&values); err != nil {
+		return err
+	}
+	order := yaml.MapSlice{}
+	if err := unmarshal(&order); err != nil {
+		return err
+	}
+	globs := FlowGlobs[T]{}
+	for _, item := range order {
+		pattern := fmt.Sprint(item.Key)
+		globs = append(globs, FlowGlob[T]{Pattern: pattern, Value: values[pattern]})
+	}
+	*g = globs
+	return nil
+}
+
+// Lookup returns the value of the first glob that matches the flow name (see matchFlowGlob).
+func (g FlowGlobs[T]) Lookup(name string) (T, bool) {
+	for _, glob := range g {
+		if matchFlowGlob(glob.Pattern, name) {
+			return glob.Value, true
+		}
+	}
+	var none T
+	return none, false
+}
+
+// compileFilePattern compiles the config file-pattern regex, which selects the flows
+// to run when no --flow is given. It matches the end of the flow name.
+func compileFilePattern(filePattern string) (*regexp.Regexp, error) {
+	pattern, err := regexp.Compile(filePattern + "$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid file-pattern '%s': %v", filePattern, err)
+	}
+	return pattern, nil
+}
+
+var flowTagsPattern = regexp.MustCompile(`^\s*(#|//|--|;|/\*|\*)\s*okareo-tags:\s*(.*?)\s*(\*/)?$`)
+
+// readFlowTags reads tags from an "okareo-tags:" comment in the first lines of a flow,
+// e.g. "# okareo-tags: smoke, nightly".
+func readFlowTags(path string) []string {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for i := 0; i < 30 && scanner.Scan(); i++ {
+		if match := flowTagsPattern.FindStringSubmatch(scanner.Text()); match != nil {
+			return splitTags(match[2])
+		}
+	}
+	return nil
+}
+
+func splitTags(value string) []string {
+	tags := []string{}
+	for _, tag := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// printFlowList shows what `okareo run` would run, for --list.
+func printFlowList(config_flows []*FlowConfig, flows []FlowFile) {
+	w := tabwriter.NewWriter(runOutput, 0, 0, 2, ' ', 0)
+	printRow(w, "FLOW", "RUNNER", "TAGS")
+	for _, flow := range config_flows {
+		printRow(w, flow.Name, "config", strings.Join(flow.Tags, ","))
+	}
+	for _, flow := range flows {
+		printRow(w, flow.Name, flow.Language, strings.Join(flow.Tags, ","))
+	}
+	w.Flush()
+}
+
+// the outcome of a flow in the run summary
+const (
+	flowPassed    = "passed"
+	flowFailed    = "failed"
+	flowTimedOut  = "timed out"
+	flowCancelled = "cancelled"
+)
+
+// FlowResult is one row of the summary printed at the end of `okareo run`.
+type FlowResult struct {
+	Name       string
+	Group      string
+	Runner     string
+	Status     string
+	Duration   time.Duration
+	Retries    int
+	TestRuns   []FlowTestRun
+	Metrics    map[string]interface{}
+	Assertions []FlowAssertion
+	Error      string
+	Matrix     *MatrixCell // for the test runs of a matrix config flow
+}
+
+// flowContext bounds a flow by its timeout. A zero timeout only follows the run (Ctrl-C).
+func flowContext(run_ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(run_ctx, timeout)
+	}
+	return context.WithCancel(run_ctx)
+}
+
+// flowStatus classifies the error of a flow. Call it before cancelling flow_ctx.
+func flowStatus(flow_ctx context.Context, err error) string {
+	if err == nil {
+		return flowPassed
+	}
+	switch flow_ctx.Err() {
+	case context.DeadlineExceeded:
+		return flowTimedOut
+	case context.Canceled:
+		return flowCancelled
+	}
+	return flowFailed
+}
+
+// parseTimeout reads a duration such as "90s" or "10m" from config.yml.
+func parseTimeout(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("'%s' is not a valid timeout. Use a duration such as 90s or 10m", value)
+	}
+	return timeout, nil
+}
+
+// flowTimeout returns the timeout of the first config glob matching the flow, else fallback.
+func flowTimeout(name string, timeouts FlowGlobs[string], fallback time.Duration) time.Duration {
+	if value, ok := timeouts.Lookup(name); ok {
+		timeout, _ := parseTimeout(value)
+		return timeout
+	}
+	return fallback
+}
+
+// flowRetries returns the retries of the first config glob matching the flow, else fallback.
+func flowRetries(name string, retries FlowGlobs[int], fallback int) int {
+	if value, ok := retries.Lookup(name); ok {
+		return value
+	}
+	return fallback
+}
+
+// ScriptFlow is a flow file ready to run: its command, environment and run settings.
+type ScriptFlow struct {
+	FlowFile
+	Args       []string
+	Env        []string // os.Environ() followed by AddedEnv
+	AddedEnv   []string // the variables okareo sets for the flow
+	Timeout    time.Duration
+	Retries    int
+	OutputFile string // OKAREO_JSON_OUTPUT_FILE, read back after each attempt
+	OwnOutput  bool   // OutputFile is the CLI's <reports>/<flow>.result.json rather than --outputFile
+}
+
+// runFlowScript runs a flow, retrying it up to its retries when it exits non-zero or
+// reports a failed assertion. Flows that time out or are cancelled are not retried.
+// Its output is also logged to <reports>/<flow>.log.
+func runFlowScript(run_ctx context.Context, reporter *RunReporter, script ScriptFlow, reports_dir_path string, isDebug bool) FlowResult {
+	flow_log, err := openFlowLog(reports_dir_path, script.FlowFile)
+	if err != nil {
+		fmt.Fprintln(runOutput, "Warning: Unable to create the log for "+script.Name+".", err)
+	}
+	defer flow_log.Close()
+
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		attempt_start := time.Now()
+		if script.OwnOutput {
+			// don't collect results left over from an earlier attempt or run. A file
+			// given with --outputFile belongs to the user and is never removed.
+			os.Remove(script.OutputFile)
+		}
+		flow_log.Write("okareo", fmt.Sprintf("attempt %d: %s", attempt+1, strings.Join(script.Args, " ")))
+		result := runFlowAttempt(run_ctx, reporter, flow_log, script, isDebug)
+		if result.Status == flowPassed || result.Status == flowFailed {
+			collectFlowOutput(&result, script.OutputFile, attempt_start)
+		}
+		flow_log.Write("okareo", strings.TrimSpace(fmt.Sprintf("%s after %s %s", result.Status, result.Duration.Round(time.Millisecond), result.Error)))
+		if result.Status != flowFailed || attempt >= script.Retries {
+			result.Duration = time.Since(start)
+			result.Retries = attempt
+			return result
+		}
+		fmt.Fprintf(runOutput, "Retrying %s (retry %d of %d).\n", script.Name, attempt+1, script.Retries)
+	}
+}
+
+// collectFlowOutput adds the result file of a flow to its result. Failed assertions
+// fail a flow that exited cleanly. A file last written before the attempt started is
+// left over from something else and ignored.
+func collectFlowOutput(result *FlowResult, output_file string, since time.Time) {
+	if output_file == "" {
+		return
+	}
+	// allow for file systems that keep modification times in whole seconds
+	if info, err := os.Stat(output_file); err == nil && info.ModTime().Before(since.Truncate(time.Second)) {
+		return
+	}
+	output, err := readFlowOutput(output_file)
+	if err != nil {
+		fmt.Fprintln(runOutput, "Warning:", err)
+		return
+	}
+	if output == nil {
+		return
+	}
+	result.TestRuns = output.TestRuns
+	result.Metrics = output.Metrics
+	result.Assertions = output.Assertions
+	for _, test_run := range output.TestRuns {
+		fmt.Fprintln(runOutput, "Test run: "+test_run.Name+" "+test_run.ID+" "+test_run.AppLink)
+	}
+	failed := failedAssertions(output.Assertions)
+	for _, assertion := range failed {
+		fmt.Fprintln(runOutput, "Assertion failed: "+strings.TrimSuffix(assertion.Name+": "+assertion.Message, ": "))
+	}
+	if len(failed) > 0 && result.Status == flowPassed {
+		result.Status = flowFailed
+		result.Error = fmt.Sprintf("%d of %d assertions failed", len(failed), len(output.Assertions))
+	}
+}
+
+// runFlowAttempt runs a flow command until it exits, times out or the run is cancelled.
+// The whole process group is killed when the flow is stopped.
+func runFlowAttempt(run_ctx context.Context, reporter *RunReporter, flow_log *flowLog, script ScriptFlow, isDebug bool) FlowResult {
+	flow_ctx, cancel := flowContext(run_ctx, script.Timeout)
+	defer cancel()
+
+	flow := script.FlowFile
+	timeout := script.Timeout
+	cmd := exec.CommandContext(flow_ctx, script.Args[0], script.Args[1:]...)
+	cmd.Env = script.Env
+	setProcessGroup(cmd)
+	// don't wait on pipes still held by orphaned grandchildren
+	cmd.WaitDelay = 5 * time.Second
+
+	start := time.Now()
+	err := doFlowScript(cmd, func(line string) {
+		flow_log.Write("stdout", line)
+		reporter.Line(flow.Name, line)
+	}, func(line string) {
+		flow_log.Write("stderr", line)
+		fmt.Fprint(os.Stderr, line)
+	})
+	result := FlowResult{Name: flow.Name, Group: flow.Group, Runner: flow.Language, Status: flowStatus(flow_ctx, err), Duration: time.Since(start)}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	switch result.Status {
+	case flowTimedOut:
+		fmt.Fprintln(runOutput, "Error: "+flow.Name+" timed out after "+timeout.String()+".")
+	case flowCancelled:
+		fmt.Fprintln(runOutput, "Error: "+flow.Name+" was cancelled.")
+	case flowFailed:
+		fmt.Fprintln(runOutput, "Error: "+flow.Name+" failed.", err)
+	}
+	if err != nil && isDebug {
+		fmt.Fprintln(runOutput, "Debug:", err)
+	}
+	return result
+}
+
+// doFlowScript runs a flow command, passing each line it writes to onStdout or onStderr.
+func doFlowScript(cmd *exec.Cmd, onStdout func(line string), onStderr func(line string)) error {
+	stdout := &lineWriter{onLine: onStdout}
+	stderr := &lineWriter{onLine: onStderr}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	stdout.Flush()
+	stderr.Flush()
+	return err
+}
+
+// printRunSummary lists every flow of the run and returns false when any did not pass.
+func printRunSummary(results []FlowResult) bool {
+	passed := 0
+	retries := 0
+	w := tabwriter.NewWriter(runOutput, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(runOutput, "Run summary:")
+	printRow(w, "FLOW", "STATUS", "DURATION", "RETRIES", "TEST RUNS", "ASSERTIONS")
+	for _, result := range results {
+		if result.Status == flowPassed {
+			passed++
+		}
+		retries += result.Retries
+		assertions := "-"
+		if len(result.Assertions) > 0 {
+			assertions = fmt.Sprintf("%d/%d passed", len(result.Assertions)-len(failedAssertions(result.Assertions)), len(result.Assertions))
+		}
+		printRow(w, result.Name, result.Status, result.Duration.Round(100*time.Millisecond).String(), strconv.Itoa(result.Retries), strconv.Itoa(len(result.TestRuns)), assertions)
+	}
+	w.Flush()
+	if retries > 0 {
+		fmt.Fprintf(runOutput, "%d of %d flows passed after %d retries.\n", passed, len(results), retries)
+	} else {
+		fmt.Fprintf(runOutput, "%d of %d flows passed.\n", passed, len(results))
+	}
+	groups := groupResults(results)
+	names := []string{}
+	for group := range groups {
+		names = append(names, group)
+	}
+	sort.Strings(names)
+	for _, group := range names {
+		summary := groups[group]
+		fmt.Fprintf(runOutput, "  %s: %d of %d passed\n", groupLabel(group), summary.Passed, summary.Total)
+	}
+	return passed == len(results)
+}
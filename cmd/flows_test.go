@@ -0,0 +1,130 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestFlowGlobsLookup(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		flow   string
+		want   string
+		found  bool
+	}{
+		{"specific glob first", "legacy/*.js: typescript\n'*.js': javascript\n", "legacy/a.js", "typescript", true},
+		{"general glob first", "'*.js': javascript\nlegacy/*.js: typescript\n", "legacy/a.js", "javascript", true},
+		{"falls through to a later glob", "legacy/*.js: typescript\n'*.js': javascript\n", "b.js", "javascript", true},
+		{"folder glob", "retrieval: python\n", "retrieval/search.txt", "python", true},
+		{"no match", "'*.js': javascript\n", "a.py", "", false},
+		{"empty", "{}\n", "a.py", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var globs FlowGlobs[string]
+			if err := yaml.Unmarshal([]byte(tt.config), &globs); err != nil {
+				t.Fatal(err)
+			}
+			got, found := globs.Lookup(tt.flow)
+			if got != tt.want || found != tt.found {
+				t.Errorf("Lookup(%q) = %q, %v, want %q, %v", tt.flow, got, found, tt.want, tt.found)
+			}
+		})
+	}
+}
+
+func TestFlowGlobsTypedValues(t *testing.T) {
+	var retries FlowGlobs[int]
+	if err := yaml.Unmarshal([]byte("flaky_*: 2\n'*': 0\n"), &retries); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := retries.Lookup("flaky_search.py"); got != 2 {
+		t.Errorf("flaky_search.py retries = %d, want 2", got)
+	}
+	if err := yaml.Unmarshal([]byte("flaky_*: often\n"), &retries); err == nil {
+		t.Error("a value that isn't an int should fail to load")
+	}
+}
+
+func TestMatchFlowGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"search.py", "search.py", true},
+		{"search.py", "retrieval/search.py", true},
+		{"search", "retrieval/search.py", true},
+		{"search", "retrieval/search_v2.py", false},
+		{"retrieval/search", "retrieval/search.py", true},
+		{"retrieval/search", "other/search.py", false},
+		{"retrieval", "retrieval/search.py", true},
+		{"retrieval", "retrieval/deep/search.py", true},
+		{"retrieval/deep", "retrieval/deep/search.py", true},
+		{"retrieval/*", "retrieval/search.py", true},
+		{"retrieval/*", "retrieval/deep/search.py", true},
+		{"*.py", "retrieval/search.py", true},
+		{"*.py", "search.ts", false},
+		{"search_*", "retrieval/search_v2.py", true},
+		{"rerank.v2.*", "rerank.v2.ts", true},
+		{"rerank.v2.ts", "rerank.v2.ts", true},
+		{"rerank.v2.js", "rerank.v2.ts", false},
+		{"retrieval\\search.py", "retrieval/search.py", filepath.Separator == '\\'},
+		{"[", "search.py", false},
+	}
+	for _, tt := range tests {
+		if got := matchFlowGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchFlowGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchAnyFlowGlob(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		name     string
+		want     bool
+	}{
+		{nil, "search.py", false},
+		{[]string{}, "search.py", false},
+		{[]string{"nightly", "search"}, "retrieval/search.py", true},
+		{[]string{"nightly", "*.ts"}, "retrieval/search.py", false},
+		{[]string{"*.ts", "retrieval"}, "retrieval/search.py", true},
+	}
+	for _, tt := range tests {
+		if got := matchAnyFlowGlob(tt.patterns, tt.name); got != tt.want {
+			t.Errorf("matchAnyFlowGlob(%q, %q) = %v, want %v", tt.patterns, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCompileFilePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+		wantErr bool
+	}{
+		{"", "search.py", true, false},
+		{`.*\.py`, "retrieval/search.py", true, false},
+		{`.*\.py`, "search.pyc", false, false},
+		{`search`, "retrieval/search_v2.py", false, false},
+		{`(`, "search.py", false, true},
+	}
+	for _, tt := range tests {
+		pattern, err := compileFilePattern(tt.pattern)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("compileFilePattern(%q) error = %v, wantErr %v", tt.pattern, err, tt.wantErr)
+			continue
+		}
+		if err == nil && pattern.MatchString(tt.name) != tt.want {
+			t.Errorf("compileFilePattern(%q) matches %q = %v, want %v", tt.pattern, tt.name, !tt.want, tt.want)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+var go_dist_folder string = "./.okareo/dist/go/"
+
+// the hash of go.mod/go.sum last downloaded for the flows module
+var go_install_marker string = go_dist_folder + ".okareo-install.sha256"
+
+var go_dependency_files = []string{"./.okareo/go.mod", "./.okareo/go.sum"}
+
+// installOkareoGo scaffolds the flows module (go.mod and the typed client) when missing
+// and downloads its dependencies when go.mod or go.sum change.
+func installOkareoGo(debug bool, reinstall bool, offline bool) error {
+	var go_mod_file string = "./.okareo/go.mod"
+	if !fileExists(go_mod_file) {
+		if debug {
+			fmt.Fprintln(runOutput, "Debug: go.mod not found. Creating one.")
+		}
+		if err := os.WriteFile(go_mod_file, getGoFlowsModule(), 0644); err != nil {
+			return err
+		}
+	}
+	var client_file string = "./.okareo/okareo/client.go"
+	if !fileExists(client_file) {
+		if err := os.MkdirAll(filepath.Dir(client_file), 0777); err != nil {
+			return err
+		}
+		if err := os.WriteFile(client_file, getGoOkareoClient(), 0644); err != nil {
+			return err
+		}
+	}
+
+	go_hash := hashFiles(go_dependency_files...)
+	if offline {
+		if readMarker(go_install_marker) != go_hash {
+			fmt.Fprintln(runOutput, "Warning: go.mod changed since the last install. Using the local module cache because of --offline.")
+		}
+		return nil
+	}
+	if !reinstall && readMarker(go_install_marker) == go_hash {
+		if debug {
+			fmt.Fprintln(runOutput, "Debug: go.mod unchanged. Skipping go mod download.")
+		}
+		return nil
+	}
+
+	cmd := exec.Command("go", "mod", "tidy")
+	cmd.Dir = "./.okareo"
+	if err := runSetupCommand(cmd, debug); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(go_dist_folder, 0777); err != nil {
+		return err
+	}
+	return os.WriteFile(go_install_marker, []byte(hashFiles(go_dependency_files...)+"\n"), 0644)
+}
+
+// goBinaryPath is where doGoBuild puts the binary for a flow file. Flows in sub
+// folders keep their folder, so retrieval/main.go and generation/main.go don't collide.
+func goBinaryPath(filename string) string {
+	var binary string = filepath.Base(filename)
+	if rel, err := filepath.Rel("./.okareo/flows", filename); err == nil && !strings.HasPrefix(rel, "..") {
+		binary = rel
+	}
+	binary = strings.TrimSuffix(binary, ".go")
+	if runtime.GOOS == "windows" {
+		binary += ".exe"
+	}
+	out, err := filepath.Abs(filepath.Join(go_dist_folder, binary))
+	check(err)
+	return out
+}
+
+// doGoBuild compiles a single flow file into a binary under .okareo/dist/go and returns its path.
+func doGoBuild(filename string, offline bool, isDebug bool) (string, error) {
+	out := goBinaryPath(filename)
+	source, err := filepath.Abs(filename)
+	if err != nil {
+		return "", err
+	}
+
+	if isDebug {
+		fmt.Fprintln(runOutput, "Debug: Building", filename)
+	}
+	if err := os.MkdirAll(filepath.Dir(out), 0777); err != nil {
+		return "", err
+	}
+	cmd := exec.Command("go", "build", "-o", out, source)
+	cmd.Dir = "./.okareo"
+	cmd.Env = os.Environ()
+	if offline {
+		cmd.Env = append(cmd.Env, "GOPROXY=off")
+	}
+	cmd.Stdout = runOutput
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("unable to build the Go flow %s: %v", filename, err)
+	}
+	return out, nil
+}
+
+func getGoFlowsModule() []byte {
+	return []byte(`module okareo-flows
+
+go 1.21
+`)
+}
+
+// goSource lets the Go templates below use ~ for struct tag quotes, since a raw
+// string literal cannot contain backticks.
+func goSource(source string) []byte {
+	return []byte(strings.ReplaceAll(source, "~", "`"))
+}
+
+func getExampleGoFlow() []byte {
+	return goSource(`package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"okareo-flows/okareo"
+)
+
+func main() {
+	client, err := okareo.NewFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Go example to get projects")
+	projects, err := client.GetProjects(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, project := range projects {
+		fmt.Println("Project:", project.Name, project.ID)
+	}
+}
+`)
+}
+
+func getGoOkareoClient() []byte {
+	return goSource(`// Package okareo is a small typed client for the Okareo API, scaffolded by 'okareo init -l go'.
+// It reads the environment that 'okareo run' passes to every flow.
+package okareo
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+type Client struct {
+	APIKey    string
+	BaseURL   string
+	ProjectID string
+	RunID     string
+	ReportDir string
+	HTTP      *http.Client
+}
+
+type Project struct {
+	ID   string   ~json:"id"~
+	Name string   ~json:"name"~
+	Tags []string ~json:"tags"~
+}
+
+type Model struct {
+	ID        string                 ~json:"id"~
+	ProjectID string                 ~json:"project_id"~
+	Name      string                 ~json:"name"~
+	Models    map[string]interface{} ~json:"models"~
+	Tags      []string               ~json:"tags"~
+	AppLink   string                 ~json:"app_link"~
+}
+
+type TestRunRequest struct {
+	Name             string            ~json:"name"~
+	ProjectID        string            ~json:"project_id"~
+	MutID            string            ~json:"mut_id"~
+	ScenarioID       string            ~json:"scenario_id"~
+	Type             string            ~json:"type"~
+	Checks           []string          ~json:"checks,omitempty"~
+	Tags             []string          ~json:"tags,omitempty"~
+	APIKeys          map[string]string ~json:"api_keys,omitempty"~
+	CalculateMetrics bool              ~json:"calculate_metrics"~
+}
+
+type TestRun struct {
+	ID                 string                 ~json:"id"~
+	ProjectID          string                 ~json:"project_id"~
+	MutID              string                 ~json:"mut_id"~
+	ScenarioSetID      string                 ~json:"scenario_set_id"~
+	Name               string                 ~json:"name"~
+	Tags               []string               ~json:"tags"~
+	Type               string                 ~json:"type"~
+	TestDataPointCount int                    ~json:"test_data_point_count"~
+	ModelMetrics       map[string]interface{} ~json:"model_metrics"~
+	AppLink            string                 ~json:"app_link"~
+}
+
+// NewFromEnv builds a client from OKAREO_API_KEY, OKAREO_BASE_URL, PROJECT_ID,
+// OKAREO_RUN_ID and OKAREO_REPORT_DIR. Its HTTP client trusts OKAREO_CA_BUNDLE and
+// routes through OKAREO_PROXY when they are set.
+func NewFromEnv() (*Client, error) {
+	httpClient, err := newHTTPClient(os.Getenv("OKAREO_CA_BUNDLE"), os.Getenv("OKAREO_PROXY"))
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{
+		APIKey:    os.Getenv("OKAREO_API_KEY"),
+		BaseURL:   os.Getenv("OKAREO_BASE_URL"),
+		ProjectID: os.Getenv("PROJECT_ID"),
+		RunID:     os.Getenv("OKAREO_RUN_ID"),
+		ReportDir: os.Getenv("OKAREO_REPORT_DIR"),
+		HTTP:      httpClient,
+	}
+	if client.APIKey == "" {
+		return nil, fmt.Errorf("OKAREO_API_KEY is not set")
+	}
+	if client.BaseURL == "" {
+		client.BaseURL = "https://api.okareo.com"
+	}
+	return client, nil
+}
+
+// newHTTPClient trusts the system roots plus caBundle and uses proxy for Okareo calls.
+// Without a proxy the standard HTTPS_PROXY/NO_PROXY variables apply.
+func newHTTPClient(caBundle string, proxy string) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("OKAREO_PROXY is not a valid URL: %v", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if caBundle != "" {
+		pem, err := os.ReadFile(caBundle)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read OKAREO_CA_BUNDLE: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in OKAREO_CA_BUNDLE %s", caBundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+func (c *Client) GetProjects(ctx context.Context) ([]Project, error) {
+	projects := []Project{}
+	err := c.do(ctx, http.MethodGet, "/v0/projects", nil, &projects)
+	return projects, err
+}
+
+func (c *Client) GetModel(ctx context.Context, modelID string) (*Model, error) {
+	model := &Model{}
+	err := c.do(ctx, http.MethodGet, "/v0/models_under_test/"+modelID, nil, model)
+	return model, err
+}
+
+func (c *Client) RunTest(ctx context.Context, request TestRunRequest) (*TestRun, error) {
+	if request.ProjectID == "" {
+		request.ProjectID = c.ProjectID
+	}
+	testRun := &TestRun{}
+	err := c.do(ctx, http.MethodPost, "/v0/test_run", request, testRun)
+	return testRun, err
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("api-key", c.APIKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, data)
+	}
+	return json.Unmarshal(data, out)
+}
+`)
+}
@@ -13,12 +13,17 @@ import (
 
 var initCmd = &cobra.Command{
 	Use:   "init",
-	Short: "Creates a default .okareo structure in the current directory",
-	Long:  `Creates a default .okareo structure in the current directory`,
+	Short: "Creates a default .okareo structure in the current directory or --workspace",
+	Long:  `Creates a default .okareo structure in the current directory or --workspace`,
 	Run: func(cmd *cobra.Command, args []string) {
 		isDebug, _ := cmd.Flags().GetBool("debug")
 		isForce, _ := cmd.Flags().GetBool("force")
 		language, _ := cmd.Flags().GetString("language")
+		// init creates .okareo in --workspace or the working directory, never further up
+		if err := enterWorkspace(cmd, false); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
 
 		var okareo_folder = "./.okareo"
 		var init_file = "config.yml"
@@ -52,7 +57,7 @@ var initCmd = &cobra.Command{
 			config = []byte(`name: CLI Evaluation 
 api-key: ${OKAREO_API_KEY}
 model-keys:
-  -openai: ${OPENAI_API_KEY}
+  openai: ${OPENAI_API_KEY}
 run:
   flows:
     configs:
@@ -97,6 +102,17 @@ run:
 `)
 			example_flow = getExampleTypescriptFlow()
 			flow_example_path += ".ts"
+
+		} else if strings.ToLower(language) == "go" || strings.ToLower(language) == "golang" {
+			config = []byte(`name: CLI Evaluation 
+api-key: ${OKAREO_API_KEY}
+language: "go"
+run:
+  flows:
+    file-pattern: '.*\.go'
+`)
+			example_flow = getExampleGoFlow()
+			flow_example_path += ".go"
 		}
 
 		_, err_okareo_folder := os.Stat(okareo_folder)
@@ -131,14 +147,22 @@ run:
 			}
 		}
 		// at the end init the env for use in run
+		var install_err error
 		if strings.ToLower(language) == "python" || strings.ToLower(language) == "py" {
-			installOkareoPython(isDebug)
+			install_err = installOkareoPython(isDebug, isForce, false)
 
 		} else if strings.ToLower(language) == "javascript" || strings.ToLower(language) == "js" {
-			installOkareoJavascript(isDebug)
+			install_err = installOkareoJavascript(isDebug, isForce, false)
 
 		} else if strings.ToLower(language) == "typescript" || strings.ToLower(language) == "ts" {
-			installOkareoTypescript(isDebug)
+			install_err = installOkareoTypescript(isDebug, isForce, false)
+
+		} else if strings.ToLower(language) == "go" || strings.ToLower(language) == "golang" {
+			install_err = installOkareoGo(isDebug, isForce, false)
+		}
+		if install_err != nil {
+			fmt.Println("Error: Unable to install the flow dependencies.", install_err)
+			os.Exit(1)
 		}
 
 	},
@@ -188,7 +212,7 @@ main();`)
 
 func init() {
 	rootCmd.AddCommand(initCmd)
-	initCmd.PersistentFlags().StringP("language", "l", "", "The language you want to configure: Python, Javascript, or Typescript.")
+	initCmd.PersistentFlags().StringP("language", "l", "", "The language you want to configure: Python, Javascript, Typescript, or Go.")
 	initCmd.PersistentFlags().BoolP("force", "f", false, "Forces the exiting configuration to be overwritten.")
 	initCmd.PersistentFlags().BoolP("debug", "d", false, "See additional stdout to debug the init process.")
 }
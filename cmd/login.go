@@ -0,0 +1,216 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Store an Okareo API key for use by the CLI",
+	Long: `Verifies an Okareo API key and stores it in your per-user credentials file.
+The stored key is used by 'okareo run' and 'okareo proxy' when no key is set in config.yml or OKAREO_API_KEY.
+Use --account to keep keys for several Okareo accounts side by side.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		isDebug, _ := cmd.Flags().GetBool("debug")
+		apiKey, _ := cmd.Flags().GetString("api-key")
+		noVerify, _ := cmd.Flags().GetBool("no-verify")
+		modelKeyFlags, _ := cmd.Flags().GetStringArray("model-key")
+
+		creds, err := loadCredentials()
+		if err != nil {
+			fmt.Println("Error: Unable to read the credentials file.", err)
+			os.Exit(1)
+		}
+		// the same account whoami, logout and projects use act on
+		account := selectedAccount(creds)
+		model_keys := ModelKeys{}
+		existing, keep_account := creds.Accounts[account]
+		if keep_account {
+			for provider, key := range existing.ModelKeys {
+				model_keys[provider] = key
+			}
+			// adding model keys to a stored account doesn't need its API key again
+			keep_account = apiKey == "" && len(modelKeyFlags) > 0
+			if keep_account {
+				apiKey = existing.APIKey
+				noVerify = true
+			}
+		}
+		for _, entry := range modelKeyFlags {
+			provider, key, ok := strings.Cut(entry, "=")
+			if !ok || provider == "" {
+				fmt.Println("Error: --model-key takes provider=key, e.g. anthropic=sk-ant-...")
+				os.Exit(1)
+			}
+			if key == "" {
+				delete(model_keys, strings.ToLower(provider))
+			} else {
+				model_keys[strings.ToLower(provider)] = key
+			}
+		}
+
+		if apiKey == "" {
+			apiKey, err = readAPIKey()
+			if err != nil {
+				fmt.Println("Error: Unable to read the API key.", err)
+				os.Exit(1)
+			}
+		}
+		if apiKey == "" {
+			fmt.Println("Error: An API key is required. Create one at https://app.okareo.com/settings/api-token")
+			os.Exit(1)
+		}
+
+		if !noVerify {
+			projects, err := get_projects(apiKey)
+			if err != nil {
+				fmt.Println("Error: The API key could not be verified with Okareo.")
+				if isDebug {
+					fmt.Println(err)
+				}
+				os.Exit(1)
+			}
+			if isDebug {
+				fmt.Println("Debug: API key has access to", len(projects), "project(s).")
+			}
+		}
+
+		// update a stored account in place, so its default project is kept
+		stored, ok := creds.Accounts[account]
+		if !ok {
+			stored = &Account{}
+			creds.Accounts[account] = stored
+		}
+		stored.ModelKeys = model_keys
+		if !keep_account {
+			stored.APIKey = apiKey
+			// remember a self-hosted endpoint so later commands reach the same deployment
+			if baseURL := firstSetting("base-url", "OKAREO_BASE_URL", ""); baseURL != "" {
+				stored.BaseURL = baseURL
+			}
+		}
+		creds.Current = account
+		if err := saveCredentials(creds); err != nil {
+			fmt.Println("Error: Unable to write the credentials file.", err)
+			os.Exit(1)
+		}
+		path, _ := credentialsFilePath()
+		fmt.Println("Logged in to account '" + account + "'. Credentials stored in " + path)
+	},
+}
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Remove a stored Okareo API key",
+	Long:  `Removes the API key for the selected account (or every account with --all) from your per-user credentials file.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		all, _ := cmd.Flags().GetBool("all")
+
+		creds, err := loadCredentials()
+		if err != nil {
+			fmt.Println("Error: Unable to read the credentials file.", err)
+			os.Exit(1)
+		}
+
+		if all {
+			creds.Accounts = map[string]*Account{}
+			creds.Current = ""
+		} else {
+			account := selectedAccount(creds)
+			if _, ok := creds.Accounts[account]; !ok {
+				fmt.Println("Not logged in to account '" + account + "'.")
+				return
+			}
+			delete(creds.Accounts, account)
+			if creds.Current == account {
+				creds.Current = ""
+				if names := creds.accountNames(); len(names) > 0 {
+					creds.Current = names[0]
+				}
+			}
+			fmt.Println("Logged out of account '" + account + "'.")
+		}
+
+		if err := saveCredentials(creds); err != nil {
+			fmt.Println("Error: Unable to write the credentials file.", err)
+			os.Exit(1)
+		}
+		if all {
+			fmt.Println("Logged out of all accounts.")
+		}
+	},
+}
+
+var whoamiCmd = &cobra.Command{
+	Use:   "whoami",
+	Short: "Show which Okareo API key the CLI will use",
+	Long:  `Shows the API key the CLI resolves (masked), where it came from, and the projects it can access.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		isDebug, _ := cmd.Flags().GetBool("debug")
+		// the same lookup as okareo run, so an api-key in config.yml shows up here too
+		config := loadCommandConfig(cmd)
+		apiKey, source := resolveAPIKey(config.APIKey)
+		if apiKey == "" {
+			fmt.Println("Not logged in. Run 'okareo login' or set OKAREO_API_KEY.")
+			os.Exit(1)
+		}
+		creds, _ := loadCredentials()
+		fmt.Println("Account:", selectedAccount(creds))
+		fmt.Println("API key:", maskSecret(apiKey), "(from "+source+")")
+		fmt.Println("Endpoint:", get_endpoint())
+
+		projects, err := get_projects(apiKey)
+		if err != nil {
+			fmt.Println("Error: The API key could not be verified with Okareo.")
+			if isDebug {
+				fmt.Println(err)
+			}
+			os.Exit(1)
+		}
+		names := make([]string, 0, len(projects))
+		for _, project := range projects {
+			names = append(names, project.Name)
+		}
+		fmt.Println("Projects:", strings.Join(names, ", "))
+	},
+}
+
+// readAPIKey prompts for the key without echo on a terminal, or reads one line from piped stdin.
+func readAPIKey() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Print("Okareo API key: ")
+		key, err := term.ReadPassword(fd)
+		fmt.Println()
+		return strings.TrimSpace(string(key)), err
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+func init() {
+	rootCmd.AddCommand(loginCmd)
+	loginCmd.Flags().StringP("api-key", "k", "", "The Okareo API key to store. Prompted for when omitted.")
+	loginCmd.Flags().StringArray("model-key", nil, "Store a model provider key as provider=key, e.g. anthropic=sk-ant-... Used by config flows when config.yml and the environment have none. Repeat for several providers; an empty key removes one.")
+	loginCmd.Flags().Bool("no-verify", false, "Store the key without verifying it against the Okareo API.")
+	loginCmd.Flags().BoolP("debug", "d", false, "See additional stdout to debug the login process.")
+
+	rootCmd.AddCommand(logoutCmd)
+	logoutCmd.Flags().Bool("all", false, "Remove the keys for every stored account.")
+
+	rootCmd.AddCommand(whoamiCmd)
+	whoamiCmd.Flags().BoolP("debug", "d", false, "See additional stdout to debug the API key lookup.")
+	whoamiCmd.Flags().StringP("config", "c", "./.okareo/config.yml", "The Okareo configuration file whose api-key and endpoint settings apply.")
+}
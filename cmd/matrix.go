@@ -0,0 +1,211 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// FlowMatrix expands one config flow into a test run per model × scenario × parameter set.
+//
+//	matrix:
+//	  models: [MODEL_ID_A, MODEL_ID_B]
+//	  scenarios: [SCENARIO_ID_A, SCENARIO_ID_B]
+//	  params:
+//	    - name: strict
+//	      checks: [fluency, coherence]
+//	    - name: loose
+//
+// A missing dimension uses the flow's own model-id or scenario-id. Parameter sets are
+// named by "name"; their other fields are sent in the /v0/test_run request, except
+// for the reservedTestRunFields that okareo run sets itself.
+type FlowMatrix struct {
+	Models    []string                 `yaml:"models"`
+	Scenarios []string                 `yaml:"scenarios"`
+	Params    []map[string]interface{} `yaml:"params"`
+}
+
+// MatrixCell identifies the test run of one combination of a matrix flow.
+type MatrixCell struct {
+	Flow      string                 `json:"flow"`
+	Model     string                 `json:"model"`
+	ModelName string                 `json:"model_name,omitempty"`
+	Scenario  string                 `json:"scenario"`
+	Params    string                 `json:"params,omitempty"`
+	Fields    map[string]interface{} `json:"-"` // the parameter set, merged into the test run request
+}
+
+// the /v0/test_run fields okareo run resolves, with the config setting to use instead
+var reservedTestRunFields = map[string]string{
+	"mut_id":      "matrix models",
+	"scenario_id": "matrix scenarios",
+	"project_id":  "the flow's project-id",
+	"api_keys":    "model-keys",
+	"tags":        "the flow's tags",
+}
+
+// expandMatrixFlows replaces each config flow that has a matrix with one flow per
+// combination, named like "pick-model [MODEL_ID, SCENARIO_ID, strict]". A parameter
+// set with a reserved field is an error.
+func expandMatrixFlows(flows []*FlowConfig) ([]*FlowConfig, error) {
+	expanded := []*FlowConfig{}
+	for _, flow := range flows {
+		if flow.Matrix == nil {
+			expanded = append(expanded, flow)
+			continue
+		}
+		models := flow.Matrix.Models
+		if len(models) == 0 {
+			models = []string{flow.Model_id}
+		}
+		scenarios := flow.Matrix.Scenarios
+		if len(scenarios) == 0 {
+			scenarios = []string{flow.Scenario_id}
+		}
+		params := flow.Matrix.Params
+		if len(params) == 0 {
+			params = []map[string]interface{}{nil}
+		}
+		for i, param := range params {
+			for key := range param {
+				if instead, ok := reservedTestRunFields[key]; ok {
+					name := fmt.Sprint(param["name"])
+					if param["name"] == nil {
+						name = "params-" + strconv.Itoa(i+1)
+					}
+					return nil, fmt.Errorf("the matrix params '%s' of flow '%s' can't set %s, which okareo run sets itself. Use %s instead", name, flow.Name, key, instead)
+				}
+			}
+		}
+		for _, model := range models {
+			for _, scenario := range scenarios {
+				for i, param := range params {
+					cell := &MatrixCell{Flow: flow.Name, Model: model, Scenario: scenario, Fields: map[string]interface{}{}}
+					labels := []string{}
+					if len(flow.Matrix.Models) > 0 {
+						labels = append(labels, model)
+					}
+					if len(flow.Matrix.Scenarios) > 0 {
+						labels = append(labels, scenario)
+					}
+					if param != nil {
+						cell.Params = fmt.Sprint(param["name"])
+						if param["name"] == nil {
+							cell.Params = "params-" + strconv.Itoa(i+1)
+						}
+						labels = append(labels, cell.Params)
+						for key, value := range param {
+							if key != "name" {
+								cell.Fields[key] = jsonValue(value)
+							}
+						}
+					}
+					cell_flow := *flow
+					cell_flow.Name = flow.Name + " [" + strings.Join(labels, ", ") + "]"
+					cell_flow.Model_id = model
+					cell_flow.Scenario_id = scenario
+					cell_flow.Matrix = nil
+					cell_flow.Cell = cell
+					expanded = append(expanded, &cell_flow)
+				}
+			}
+		}
+	}
+	return expanded, nil
+}
+
+// selectName is the name --flow and --exclude match: the matrix flow for its cells.
+func (flow *FlowConfig) selectName() string {
+	if flow.Cell != nil {
+		return flow.Cell.Flow
+	}
+	return flow.Name
+}
+
+// jsonValue converts the map[interface{}]interface{} values yaml.v2 produces into
+// values encoding/json can marshal.
+func jsonValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		converted := map[string]interface{}{}
+		for key, item := range value {
+			converted[fmt.Sprint(key)] = jsonValue(item)
+		}
+		return converted
+	case []interface{}:
+		for i, item := range value {
+			value[i] = jsonValue(item)
+		}
+		return value
+	}
+	return value
+}
+
+// printMatrixComparison prints a table per matrix flow comparing the metrics of its
+// test runs side by side.
+func printMatrixComparison(results []FlowResult) {
+	flows := []string{}
+	by_flow := map[string][]FlowResult{}
+	for _, result := range results {
+		if result.Matrix == nil {
+			continue
+		}
+		if _, ok := by_flow[result.Matrix.Flow]; !ok {
+			flows = append(flows, result.Matrix.Flow)
+		}
+		by_flow[result.Matrix.Flow] = append(by_flow[result.Matrix.Flow], result)
+	}
+
+	for _, flow := range flows {
+		cells := by_flow[flow]
+		metric_names := []string{}
+		cell_metrics := make([]map[string]string, len(cells))
+		seen := map[string]bool{}
+		for i, result := range cells {
+			cell_metrics[i] = map[string]string{}
+			for _, metric := range flattenMetrics("", result.Metrics) {
+				// checks report under mean_scores; the check name is enough here
+				name := strings.TrimPrefix(metric[0], "mean_scores.")
+				cell_metrics[i][name] = metric[1]
+				if !seen[name] {
+					seen[name] = true
+					metric_names = append(metric_names, name)
+				}
+			}
+		}
+		sort.Strings(metric_names)
+
+		fmt.Fprintln(runOutput)
+		fmt.Fprintln(runOutput, "Matrix: "+flow)
+		w := tabwriter.NewWriter(runOutput, 0, 0, 2, ' ', 0)
+		header := []string{"MODEL", "SCENARIO", "PARAMS", "STATUS"}
+		for _, name := range metric_names {
+			header = append(header, strings.ToUpper(name))
+		}
+		printRow(w, header...)
+		for i, result := range cells {
+			model := result.Matrix.Model
+			if result.Matrix.ModelName != "" {
+				model = result.Matrix.ModelName
+			}
+			row := []string{model, result.Matrix.Scenario, valueOrDash(result.Matrix.Params), result.Status}
+			for _, name := range metric_names {
+				row = append(row, valueOrDash(cell_metrics[i][name]))
+			}
+			printRow(w, row...)
+		}
+		w.Flush()
+	}
+}
+
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
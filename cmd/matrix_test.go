@@ -0,0 +1,142 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandMatrixFlows(t *testing.T) {
+	tests := []struct {
+		name      string
+		flow      FlowConfig
+		want      []string
+		models    []string
+		scenarios []string
+		params    []string
+	}{
+		{
+			name:      "no matrix",
+			flow:      FlowConfig{Name: "plain", Model_id: "m1", Scenario_id: "s1"},
+			want:      []string{"plain"},
+			models:    []string{"m1"},
+			scenarios: []string{"s1"},
+			params:    []string{""},
+		},
+		{
+			name:      "models only",
+			flow:      FlowConfig{Name: "pick", Scenario_id: "s1", Matrix: &FlowMatrix{Models: []string{"m1", "m2"}}},
+			want:      []string{"pick [m1]", "pick [m2]"},
+			models:    []string{"m1", "m2"},
+			scenarios: []string{"s1", "s1"},
+			params:    []string{"", ""},
+		},
+		{
+			name:      "models by scenarios",
+			flow:      FlowConfig{Name: "pick", Matrix: &FlowMatrix{Models: []string{"m1", "m2"}, Scenarios: []string{"s1", "s2"}}},
+			want:      []string{"pick [m1, s1]", "pick [m1, s2]", "pick [m2, s1]", "pick [m2, s2]"},
+			models:    []string{"m1", "m1", "m2", "m2"},
+			scenarios: []string{"s1", "s2", "s1", "s2"},
+			params:    []string{"", "", "", ""},
+		},
+		{
+			name: "params with and without a name",
+			flow: FlowConfig{Name: "tune", Model_id: "m1", Scenario_id: "s1", Matrix: &FlowMatrix{Params: []map[string]interface{}{
+				{"name": "strict", "checks": []interface{}{"fluency"}},
+				{"checks": []interface{}{"coherence"}},
+			}}},
+			want:      []string{"tune [strict]", "tune [params-2]"},
+			models:    []string{"m1", "m1"},
+			scenarios: []string{"s1", "s1"},
+			params:    []string{"strict", "params-2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flow := tt.flow
+			expanded, err := expandMatrixFlows([]*FlowConfig{&flow})
+			if err != nil {
+				t.Fatal(err)
+			}
+			names, models, scenarios, params := []string{}, []string{}, []string{}, []string{}
+			for _, cell := range expanded {
+				names = append(names, cell.Name)
+				models = append(models, cell.Model_id)
+				scenarios = append(scenarios, cell.Scenario_id)
+				if cell.Cell == nil {
+					params = append(params, "")
+					continue
+				}
+				params = append(params, cell.Cell.Params)
+				if cell.Matrix != nil {
+					t.Errorf("%s still has a matrix", cell.Name)
+				}
+				if cell.selectName() != tt.flow.Name {
+					t.Errorf("%s selects as %s, want %s", cell.Name, cell.selectName(), tt.flow.Name)
+				}
+			}
+			for _, got := range []struct {
+				what string
+				got  []string
+				want []string
+			}{{"names", names, tt.want}, {"models", models, tt.models}, {"scenarios", scenarios, tt.scenarios}, {"params", params, tt.params}} {
+				if !reflect.DeepEqual(got.got, got.want) {
+					t.Errorf("%s = %q, want %q", got.what, got.got, got.want)
+				}
+			}
+		})
+	}
+}
+
+func TestExpandMatrixFlowsParamFields(t *testing.T) {
+	flow := &FlowConfig{Name: "tune", Model_id: "m1", Matrix: &FlowMatrix{Params: []map[string]interface{}{
+		{"name": "strict", "checks": []interface{}{"fluency"}, "options": map[interface{}]interface{}{"temperature": 0}},
+	}}}
+	expanded, err := expandMatrixFlows([]*FlowConfig{flow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expanded) != 1 {
+		t.Fatalf("got %d flows, want 1", len(expanded))
+	}
+	want := map[string]interface{}{
+		"checks":  []interface{}{"fluency"},
+		"options": map[string]interface{}{"temperature": 0},
+	}
+	if got := expanded[0].Cell.Fields; !reflect.DeepEqual(got, want) {
+		t.Errorf("fields = %v, want %v", got, want)
+	}
+	if flow.Matrix == nil {
+		t.Error("expandMatrixFlows changed the matrix flow")
+	}
+}
+
+func TestExpandMatrixFlowsReservedFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  []map[string]interface{}
+		wantErr string
+	}{
+		{"allowed fields", []map[string]interface{}{{"name": "strict", "checks": []interface{}{"fluency"}, "type": "MULTI_TURN", "calculate_metrics": true}}, ""},
+		{"name is the label", []map[string]interface{}{{"name": "strict"}}, ""},
+		{"api_keys", []map[string]interface{}{{"name": "strict", "api_keys": map[interface{}]interface{}{"openai": "sk"}}}, "the matrix params 'strict' of flow 'tune' can't set api_keys, which okareo run sets itself. Use model-keys instead"},
+		{"project_id", []map[string]interface{}{{"name": "strict", "project_id": "p2"}}, "the matrix params 'strict' of flow 'tune' can't set project_id, which okareo run sets itself. Use the flow's project-id instead"},
+		{"mut_id without a name", []map[string]interface{}{{"mut_id": "m2"}}, "the matrix params 'params-1' of flow 'tune' can't set mut_id, which okareo run sets itself. Use matrix models instead"},
+		{"scenario_id in a later set", []map[string]interface{}{{"name": "ok"}, {"name": "bad", "scenario_id": "s2"}}, "the matrix params 'bad' of flow 'tune' can't set scenario_id, which okareo run sets itself. Use matrix scenarios instead"},
+		{"tags", []map[string]interface{}{{"name": "strict", "tags": []interface{}{"x"}}}, "the matrix params 'strict' of flow 'tune' can't set tags, which okareo run sets itself. Use the flow's tags instead"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flow := &FlowConfig{Name: "tune", Model_id: "m1", Matrix: &FlowMatrix{Params: tt.params}}
+			_, err := expandMatrixFlows([]*FlowConfig{flow})
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Errorf("error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
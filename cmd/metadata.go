@@ -0,0 +1,226 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// RunMetadata ties a run to the commit and CI build it ran for. It is attached as tags
+// to the test runs the CLI creates and passed to script flows as OKAREO_* variables.
+type RunMetadata struct {
+	GitSHA      string
+	GitShortSHA string
+	GitBranch   string
+	GitDirty    bool
+	PRNumber    string
+	CIProvider  string // github-actions, gitlab, jenkins or circleci
+	CIBuildURL  string
+}
+
+var pullRequestRefPattern = regexp.MustCompile(`(?:refs/pull/|/pull/)(\d+)`)
+
+// detectRunMetadata reads the CI provider's variables and falls back to git for what
+// they don't cover. CI checkouts are often detached, so the CI branch wins.
+func detectRunMetadata() RunMetadata {
+	metadata := RunMetadata{}
+	switch {
+	case os.Getenv("GITHUB_ACTIONS") == "true":
+		metadata.CIProvider = "github-actions"
+		metadata.GitSHA = os.Getenv("GITHUB_SHA")
+		// GITHUB_SHA is the merge commit GitHub creates for pull_request events
+		if event := os.Getenv("GITHUB_EVENT_NAME"); event == "pull_request" || event == "pull_request_target" {
+			if sha := githubPullRequestSHA(os.Getenv("GITHUB_EVENT_PATH")); sha != "" {
+				metadata.GitSHA = sha
+			}
+		}
+		metadata.GitBranch = firstEnv("GITHUB_HEAD_REF", "GITHUB_REF_NAME")
+		if match := pullRequestRefPattern.FindStringSubmatch(os.Getenv("GITHUB_REF")); match != nil {
+			metadata.PRNumber = match[1]
+		}
+		if os.Getenv("GITHUB_RUN_ID") != "" {
+			metadata.CIBuildURL = os.Getenv("GITHUB_SERVER_URL") + "/" + os.Getenv("GITHUB_REPOSITORY") + "/actions/runs/" + os.Getenv("GITHUB_RUN_ID")
+		}
+	case os.Getenv("GITLAB_CI") == "true":
+		metadata.CIProvider = "gitlab"
+		metadata.GitSHA = os.Getenv("CI_COMMIT_SHA")
+		metadata.GitBranch = firstEnv("CI_MERGE_REQUEST_SOURCE_BRANCH_NAME", "CI_COMMIT_REF_NAME")
+		metadata.PRNumber = os.Getenv("CI_MERGE_REQUEST_IID")
+		metadata.CIBuildURL = os.Getenv("CI_JOB_URL")
+	case os.Getenv("JENKINS_URL") != "":
+		metadata.CIProvider = "jenkins"
+		metadata.GitSHA = os.Getenv("GIT_COMMIT")
+		metadata.GitBranch = strings.TrimPrefix(firstEnv("CHANGE_BRANCH", "BRANCH_NAME", "GIT_BRANCH"), "origin/")
+		metadata.PRNumber = os.Getenv("CHANGE_ID")
+		metadata.CIBuildURL = os.Getenv("BUILD_URL")
+	case os.Getenv("CIRCLECI") == "true":
+		metadata.CIProvider = "circleci"
+		metadata.GitSHA = os.Getenv("CIRCLE_SHA1")
+		metadata.GitBranch = os.Getenv("CIRCLE_BRANCH")
+		metadata.PRNumber = os.Getenv("CIRCLE_PR_NUMBER")
+		if match := pullRequestRefPattern.FindStringSubmatch(os.Getenv("CIRCLE_PULL_REQUEST")); match != nil && metadata.PRNumber == "" {
+			metadata.PRNumber = match[1]
+		}
+		metadata.CIBuildURL = os.Getenv("CIRCLE_BUILD_URL")
+	}
+
+	if metadata.GitSHA == "" {
+		metadata.GitSHA = gitOutput("rev-parse", "HEAD")
+	}
+	if metadata.GitBranch == "" {
+		if branch := gitOutput("rev-parse", "--abbrev-ref", "HEAD"); branch != "HEAD" {
+			metadata.GitBranch = branch
+		}
+	}
+	if metadata.GitSHA != "" {
+		metadata.GitShortSHA = metadata.GitSHA
+		if len(metadata.GitShortSHA) > 7 {
+			metadata.GitShortSHA = metadata.GitShortSHA[:7]
+		}
+		metadata.GitDirty = gitOutput("status", "--porcelain", "--untracked-files=no") != ""
+	}
+	return metadata
+}
+
+// githubPullRequestSHA reads the head commit of the pull request from the GitHub
+// Actions event payload, or returns "" when it can't be read.
+func githubPullRequestSHA(event_path string) string {
+	if event_path == "" {
+		return ""
+	}
+	data, err := os.ReadFile(event_path)
+	if err != nil {
+		return ""
+	}
+	var event struct {
+		PullRequest struct {
+			Head struct {
+				SHA string `json:"sha"`
+			} `json:"head"`
+		} `json:"pull_request"`
+	}
+	if json.Unmarshal(data, &event) != nil {
+		return ""
+	}
+	return event.PullRequest.Head.SHA
+}
+
+// Tags are the test run tags for the metadata, e.g. git-sha:1a2b3c4 and ci:gitlab.
+func (m RunMetadata) Tags() []string {
+	tags := []string{}
+	if m.GitSHA != "" {
+		tags = append(tags, "git-sha:"+m.GitShortSHA)
+	}
+	if m.GitBranch != "" {
+		tags = append(tags, "git-branch:"+m.GitBranch)
+	}
+	if m.GitDirty {
+		tags = append(tags, "git-dirty")
+	}
+	if m.PRNumber != "" {
+		tags = append(tags, "pr:"+m.PRNumber)
+	}
+	if m.CIProvider != "" {
+		tags = append(tags, "ci:"+m.CIProvider)
+	}
+	return tags
+}
+
+// Env passes the metadata to script flows.
+func (m RunMetadata) Env() []string {
+	env := []string{}
+	if m.GitSHA != "" {
+		env = append(env, "OKAREO_GIT_SHA="+m.GitSHA, "OKAREO_GIT_DIRTY="+strconv.FormatBool(m.GitDirty))
+	}
+	if m.GitBranch != "" {
+		env = append(env, "OKAREO_GIT_BRANCH="+m.GitBranch)
+	}
+	if m.PRNumber != "" {
+		env = append(env, "OKAREO_PR_NUMBER="+m.PRNumber)
+	}
+	if m.CIProvider != "" {
+		env = append(env, "OKAREO_CI_PROVIDER="+m.CIProvider)
+	}
+	if m.CIBuildURL != "" {
+		env = append(env, "OKAREO_CI_BUILD_URL="+m.CIBuildURL)
+	}
+	return env
+}
+
+// the run name when config.yml has no run-name, config name plus a random suffix
+const defaultRunNameTemplate = "{{.Name}}-{{.Random}}"
+
+// RunNameData is available to run-name templates, e.g. "{{.Name}}-{{.GitShortSHA}}-{{.Date}}".
+type RunNameData struct {
+	Name        string // name in config.yml
+	Random      string // 10 random hex digits
+	Date        string // 2006-01-02
+	Time        string // 150405
+	GitSHA      string
+	GitShortSHA string
+	GitBranch   string
+	PRNumber    string
+	CIProvider  string
+}
+
+// runName renders a run-name template for the config name and run metadata.
+func runName(name_template string, name string, metadata RunMetadata) (string, error) {
+	if name_template == "" {
+		name_template = defaultRunNameTemplate
+	}
+	tmpl, err := template.New("run-name").Option("missingkey=error").Parse(name_template)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	data := RunNameData{
+		Name:        name,
+		Random:      fmt.Sprintf("%X", b),
+		Date:        now.Format("2006-01-02"),
+		Time:        now.Format("150405"),
+		GitSHA:      metadata.GitSHA,
+		GitShortSHA: metadata.GitShortSHA,
+		GitBranch:   metadata.GitBranch,
+		PRNumber:    metadata.PRNumber,
+		CIProvider:  metadata.CIProvider,
+	}
+	var run_name bytes.Buffer
+	if err := tmpl.Execute(&run_name, data); err != nil {
+		return "", err
+	}
+	return run_name.String(), nil
+}
+
+// gitOutput runs git in the working directory and returns its trimmed output, or ""
+// when git is missing or this isn't a repository.
+func gitOutput(args ...string) string {
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,79 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGithubPullRequestSHA(t *testing.T) {
+	tests := []struct {
+		name  string
+		event string
+		want  string
+	}{
+		{"pull request", `{"number": 7, "pull_request": {"head": {"sha": "abc123"}}}`, "abc123"},
+		{"push", `{"after": "def456"}`, ""},
+		{"invalid", `{"pull_request":`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event_path := filepath.Join(t.TempDir(), "event.json")
+			if err := os.WriteFile(event_path, []byte(tt.event), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if got := githubPullRequestSHA(event_path); got != tt.want {
+				t.Errorf("githubPullRequestSHA() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if got := githubPullRequestSHA(filepath.Join(t.TempDir(), "missing.json")); got != "" {
+		t.Errorf("githubPullRequestSHA(missing) = %q, want empty", got)
+	}
+}
+
+func TestTestRunBody(t *testing.T) {
+	tests := []struct {
+		name string
+		flow FlowConfig
+		tags []string
+		want map[string]interface{}
+	}{
+		{
+			name: "quotes and backslashes",
+			flow: FlowConfig{Name: `say "hi" \ bye`, Project_id: "p1", Scenario_id: "s1", Model_id: "m1", Type: "NL_GENERATION", Checks: []string{`a"b`}},
+			tags: []string{`team:"ml"`},
+			want: map[string]interface{}{
+				"name": `say "hi" \ bye`, "project_id": "p1", "scenario_id": "s1", "mut_id": "m1", "type": "NL_GENERATION",
+				"calculate_metrics": "true", "api_keys": map[string]interface{}{"openai": "sk"},
+				"checks": []interface{}{`a"b`}, "tags": []interface{}{`team:"ml"`},
+			},
+		},
+		{
+			name: "matrix cell fields",
+			flow: FlowConfig{Name: "pick [m1, strict]", Model_id: "m1", Cell: &MatrixCell{Fields: map[string]interface{}{"checks": []interface{}{"fluency"}, "type": "MULTI_TURN"}}},
+			want: map[string]interface{}{
+				"name": "pick [m1, strict]", "project_id": "", "scenario_id": "", "mut_id": "m1", "type": "MULTI_TURN",
+				"calculate_metrics": "true", "api_keys": map[string]interface{}{"openai": "sk"},
+				"checks": []interface{}{"fluency"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := test_run_body([]ProviderKey{{Provider: "openai", Key: "sk"}}, &tt.flow, tt.tags)
+			got := map[string]interface{}{}
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Fatalf("invalid JSON %s: %v", body, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("test_run_body() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
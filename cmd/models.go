@@ -0,0 +1,269 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+var modelsCmd = &cobra.Command{
+	Use:   "models",
+	Short: "Manage Okareo models under test",
+	Long:  `List, inspect, register and delete the models under test referenced by 'model-id' in config.yml.`,
+}
+
+var modelsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the models under test in a project",
+	Run: func(cmd *cobra.Command, args []string) {
+		isDebug, _ := cmd.Flags().GetBool("debug")
+		projectFlag, _ := cmd.Flags().GetString("project-id")
+		nameFilter, _ := cmd.Flags().GetString("name")
+		tagFilter, _ := cmd.Flags().GetStringSlice("tag")
+
+		apiKey := requireAPIKey(cmd)
+		projectId := resolveProjectID(apiKey, projectFlag)
+
+		models := []Model{}
+		err := doOkareoRequest(apiKey, http.MethodGet, "/v0/models_under_test?project_id="+url.QueryEscape(projectId), nil, &models)
+		if err != nil {
+			printAPIError("list models", err, isDebug)
+		}
+
+		filtered := []Model{}
+		for _, model := range models {
+			if nameFilter != "" && !strings.Contains(strings.ToLower(model.Name), strings.ToLower(nameFilter)) {
+				continue
+			}
+			if !hasAllTags(model.Tags, tagFilter) {
+				continue
+			}
+			filtered = append(filtered, model)
+		}
+
+		printOutput(cmd, filtered, func(w io.Writer) {
+			printRow(w, "ID", "NAME", "TYPE", "TAGS", "CREATED")
+			for _, model := range filtered {
+				printRow(w, model.ID, model.Name, modelType(&model), strings.Join(model.Tags, ","), model.TimeCreated)
+			}
+		})
+	},
+}
+
+var modelsGetCmd = &cobra.Command{
+	Use:   "get <model-id>",
+	Short: "Show a model under test",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		isDebug, _ := cmd.Flags().GetBool("debug")
+		apiKey := requireAPIKey(cmd)
+
+		model := &Model{}
+		err := doOkareoRequest(apiKey, http.MethodGet, "/v0/models_under_test/"+url.PathEscape(args[0]), nil, model)
+		if err != nil {
+			printAPIError("get model '"+args[0]+"'", err, isDebug)
+		}
+		printOutput(cmd, model, func(w io.Writer) {
+			printModelDetails(w, model)
+		})
+	},
+}
+
+var modelsRegisterCmd = &cobra.Command{
+	Use:   "register",
+	Short: "Register a model under test",
+	Long: `Registers a model under test and prints it. The model definition is read from --file
+(JSON or YAML keyed by model type, e.g. {"openai": {"model_id": "gpt-4o", "temperature": 0}})
+or built from --type and repeated --param key=value flags.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		isDebug, _ := cmd.Flags().GetBool("debug")
+		projectFlag, _ := cmd.Flags().GetString("project-id")
+		name, _ := cmd.Flags().GetString("name")
+		tags, _ := cmd.Flags().GetStringSlice("tag")
+		file, _ := cmd.Flags().GetString("file")
+		model_type, _ := cmd.Flags().GetString("type")
+		params, _ := cmd.Flags().GetStringArray("param")
+		update, _ := cmd.Flags().GetBool("update")
+
+		if name == "" {
+			fmt.Println("Error: --name is required.")
+			os.Exit(1)
+		}
+		apiKey := requireAPIKey(cmd)
+		projectId := resolveProjectID(apiKey, projectFlag)
+
+		models := map[string]interface{}{}
+		if file != "" {
+			if err := readDataFile(file, &models); err != nil {
+				fmt.Println("Error: Unable to read the model definition '"+file+"'.", err)
+				os.Exit(1)
+			}
+		}
+		if model_type != "" {
+			settings := map[string]interface{}{}
+			for _, param := range params {
+				key, value, found := strings.Cut(param, "=")
+				if !found {
+					fmt.Println("Error: --param must be in the form key=value: " + param)
+					os.Exit(1)
+				}
+				settings[key] = parseParamValue(value)
+			}
+			models[model_type] = settings
+		}
+		if len(models) == 0 {
+			fmt.Println("Error: Provide the model definition with --file or --type.")
+			os.Exit(1)
+		}
+
+		body := map[string]interface{}{
+			"name":       name,
+			"project_id": projectId,
+			"tags":       tags,
+			"models":     models,
+			"update":     update,
+		}
+		model := &Model{}
+		if err := doOkareoRequest(apiKey, http.MethodPost, "/v0/register_model", body, model); err != nil {
+			printAPIError("register model '"+name+"'", err, isDebug)
+		}
+		if model.Warning != "" {
+			fmt.Fprintln(os.Stderr, "Warning:", model.Warning)
+		}
+		printOutput(cmd, model, func(w io.Writer) {
+			printModelDetails(w, model)
+		})
+	},
+}
+
+var modelsDeleteCmd = &cobra.Command{
+	Use:   "delete <model-id>",
+	Short: "Delete a model under test",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		isDebug, _ := cmd.Flags().GetBool("debug")
+		yes, _ := cmd.Flags().GetBool("yes")
+		apiKey := requireAPIKey(cmd)
+
+		model := &Model{}
+		err := doOkareoRequest(apiKey, http.MethodGet, "/v0/models_under_test/"+url.PathEscape(args[0]), nil, model)
+		if err != nil {
+			printAPIError("get model '"+args[0]+"'", err, isDebug)
+		}
+		if !yes && !confirm("Delete model '"+model.Name+"' ("+model.ID+")?") {
+			fmt.Println("Aborted.")
+			return
+		}
+		path := "/v0/models_under_test/" + url.PathEscape(model.ID) + "?name=" + url.QueryEscape(model.Name)
+		if err := doOkareoRequest(apiKey, http.MethodDelete, path, nil, nil); err != nil {
+			printAPIError("delete model '"+args[0]+"'", err, isDebug)
+		}
+		fmt.Println("Deleted model: " + model.ID)
+	},
+}
+
+// modelType returns the provider key of the model definition, e.g. "openai".
+func modelType(model *Model) string {
+	types := make([]string, 0, len(model.Models))
+	for model_type := range model.Models {
+		types = append(types, model_type)
+	}
+	sort.Strings(types)
+	return strings.Join(types, ",")
+}
+
+func printModelDetails(w io.Writer, model *Model) {
+	printRow(w, "ID:", model.ID)
+	printRow(w, "Name:", model.Name)
+	printRow(w, "Project:", model.ProjectID)
+	printRow(w, "Type:", modelType(model))
+	printRow(w, "Tags:", strings.Join(model.Tags, ","))
+	printRow(w, "Created:", model.TimeCreated)
+	printRow(w, "Datapoints:", fmt.Sprint(model.DatapointCount))
+	printRow(w, "Link:", model.AppLink)
+	settings, _ := json.Marshal(model.Models)
+	printRow(w, "Models:", string(settings))
+}
+
+// readDataFile decodes a JSON or YAML file (by extension) into out.
+func readDataFile(path string, out interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".yml" || ext == ".yaml" {
+		var generic interface{}
+		if err := yaml.Unmarshal(data, &generic); err != nil {
+			return err
+		}
+		// yaml.v2 decodes maps with interface{} keys, which encoding/json can't marshal
+		data, err = json.Marshal(normalizeYAML(generic))
+		if err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(data, out)
+}
+
+func normalizeYAML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = normalizeYAML(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeYAML(item)
+		}
+	}
+	return value
+}
+
+// parseParamValue keeps numbers, booleans and JSON literals typed and treats everything else as a string.
+func parseParamValue(value string) interface{} {
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(value), &parsed); err == nil {
+		return parsed
+	}
+	return value
+}
+
+func init() {
+	rootCmd.AddCommand(modelsCmd)
+	addOutputFlag(modelsCmd)
+	modelsCmd.PersistentFlags().BoolP("debug", "d", false, "See additional stdout to debug API calls.")
+
+	modelsCmd.AddCommand(modelsListCmd)
+	modelsListCmd.Flags().StringP("project-id", "p", "", "The project name or ID to list models from. Defaults to OKAREO_PROJECT_ID or the project set with 'okareo projects use'.")
+	modelsListCmd.Flags().StringP("name", "n", "", "Only show models whose name contains this text.")
+	modelsListCmd.Flags().StringSliceP("tag", "t", nil, "Only show models with this tag. Repeat to require several tags.")
+
+	modelsCmd.AddCommand(modelsGetCmd)
+
+	modelsCmd.AddCommand(modelsRegisterCmd)
+	modelsRegisterCmd.Flags().StringP("project-id", "p", "", "The project name or ID to register the model in. Defaults to OKAREO_PROJECT_ID or the project set with 'okareo projects use'.")
+	modelsRegisterCmd.Flags().StringP("name", "n", "", "The name of the model under test.")
+	modelsRegisterCmd.Flags().StringSliceP("tag", "t", nil, "A tag to attach to the model. Repeat for several tags.")
+	modelsRegisterCmd.Flags().StringP("file", "f", "", "A JSON or YAML file with the model definition keyed by model type.")
+	modelsRegisterCmd.Flags().String("type", "", "The model type, e.g. openai, cohere or custom.")
+	modelsRegisterCmd.Flags().StringArray("param", nil, "A key=value setting for --type. Repeat for several settings.")
+	modelsRegisterCmd.Flags().Bool("update", false, "Update the model if one with the same name already exists.")
+
+	modelsCmd.AddCommand(modelsDeleteCmd)
+	modelsDeleteCmd.Flags().BoolP("yes", "y", false, "Delete without asking for confirmation.")
+}
@@ -0,0 +1,90 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// addOutputFlag registers the --output flag shared by the API resource commands.
+func addOutputFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringP("output", "o", "table", "The output format: table, json or yaml.")
+}
+
+// printOutput writes data as JSON or YAML, or calls table to render it as aligned columns.
+func printOutput(cmd *cobra.Command, data interface{}, table func(w io.Writer)) {
+	format, _ := cmd.Flags().GetString("output")
+	switch strings.ToLower(format) {
+	case "json":
+		out, err := json.MarshalIndent(data, "", "  ")
+		check(err)
+		fmt.Println(string(out))
+	case "yaml", "yml":
+		// round trip through JSON so YAML keys match the API field names
+		raw, err := json.Marshal(data)
+		check(err)
+		var generic interface{}
+		check(json.Unmarshal(raw, &generic))
+		out, err := yaml.Marshal(generic)
+		check(err)
+		fmt.Print(string(out))
+	case "table", "":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		table(w)
+		w.Flush()
+	default:
+		fmt.Println("Error: Unknown output format '" + format + "'. Use table, json or yaml.")
+		os.Exit(1)
+	}
+}
+
+// printRow writes one tab separated row for a tabwriter.
+func printRow(w io.Writer, columns ...string) {
+	fmt.Fprintln(w, strings.Join(columns, "\t"))
+}
+
+// printAPIError reports a failed API call and exits.
+func printAPIError(action string, err error, isDebug bool) {
+	fmt.Println("Error: Unable to " + action + ".")
+	if apiErr, ok := err.(*APIError); ok && !isDebug {
+		fmt.Println(apiErr.Status)
+	} else {
+		fmt.Println(err)
+	}
+	os.Exit(1)
+}
+
+// confirm asks a yes/no question on the terminal. Non-interactive callers must pass --yes.
+func confirm(question string) bool {
+	fmt.Print(question + " [y/N]: ")
+	var answer string
+	fmt.Scanln(&answer)
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// hasAllTags reports whether every wanted tag is present.
+func hasAllTags(tags []string, wanted []string) bool {
+	for _, want := range wanted {
+		found := false
+		for _, tag := range tags {
+			if tag == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
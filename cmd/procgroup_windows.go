@@ -0,0 +1,18 @@
+//go:build windows
+
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// setProcessGroup makes a timeout or Ctrl-C stop the flow's whole process tree.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.Cancel = func() error {
+		return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+	}
+}
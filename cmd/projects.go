@@ -0,0 +1,216 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+type Project struct {
+	ID               string   `json:"id"`
+	Name             string   `json:"name"`
+	OnboardingStatus string   `json:"onboarding_status"`
+	Tags             []string `json:"tags"`
+}
+
+var projectsCmd = &cobra.Command{
+	Use:   "projects",
+	Short: "Manage Okareo projects",
+	Long: `List and create projects, and choose the default project used by the CLI.
+Anywhere a project is expected (--project-id, 'project-id' in config.yml) you may use its name instead of its ID.`,
+}
+
+var projectsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the projects available to your API key",
+	Run: func(cmd *cobra.Command, args []string) {
+		isDebug, _ := cmd.Flags().GetBool("debug")
+		apiKey := requireAPIKey(cmd)
+
+		projects, err := get_projects(apiKey)
+		if err != nil {
+			printAPIError("list projects", err, isDebug)
+		}
+		current := defaultProjectRef()
+		printOutput(cmd, projects, func(w io.Writer) {
+			printRow(w, "", "ID", "NAME", "TAGS")
+			for _, project := range projects {
+				marker := ""
+				if current != "" && (project.ID == current || strings.EqualFold(project.Name, current)) {
+					marker = "*"
+				}
+				printRow(w, marker, project.ID, project.Name, strings.Join(project.Tags, ","))
+			}
+		})
+	},
+}
+
+var projectsCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create a project",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		isDebug, _ := cmd.Flags().GetBool("debug")
+		tags, _ := cmd.Flags().GetStringSlice("tag")
+		use, _ := cmd.Flags().GetBool("use")
+		apiKey := requireAPIKey(cmd)
+
+		project := &Project{}
+		body := map[string]interface{}{"name": args[0], "tags": tags}
+		if err := doOkareoRequest(apiKey, http.MethodPost, "/v0/projects", body, project); err != nil {
+			printAPIError("create project '"+args[0]+"'", err, isDebug)
+		}
+		if use {
+			setDefaultProject(project)
+		}
+		printOutput(cmd, project, func(w io.Writer) {
+			printRow(w, "ID:", project.ID)
+			printRow(w, "Name:", project.Name)
+			printRow(w, "Tags:", strings.Join(project.Tags, ","))
+		})
+	},
+}
+
+var projectsUseCmd = &cobra.Command{
+	Use:   "use <name-or-id>",
+	Short: "Set the default project for the current account",
+	Long:  `Stores the default project for the current account in your credentials file. OKAREO_PROJECT_ID and --project-id still take precedence.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		isDebug, _ := cmd.Flags().GetBool("debug")
+		apiKey := requireAPIKey(cmd)
+
+		projects, err := get_projects(apiKey)
+		if err != nil {
+			printAPIError("list projects", err, isDebug)
+		}
+		project, err := findProject(projects, args[0])
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		setDefaultProject(project)
+	},
+}
+
+func get_projects(api_token string) ([]Project, error) {
+	projects := []Project{}
+	err := doOkareoRequest(api_token, http.MethodGet, "/v0/projects", nil, &projects)
+	return projects, err
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// findProject matches a project by ID or, case-insensitively, by name.
+func findProject(projects []Project, ref string) (*Project, error) {
+	matches := []*Project{}
+	for i := range projects {
+		if projects[i].ID == ref {
+			return &projects[i], nil
+		}
+		if strings.EqualFold(projects[i].Name, ref) {
+			matches = append(matches, &projects[i])
+		}
+	}
+	if len(matches) > 1 {
+		return nil, fmt.Errorf("more than one project is named '%s'. Use its ID instead", ref)
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no project named '%s' was found. Run 'okareo projects list' to see your projects", ref)
+	}
+	return matches[0], nil
+}
+
+// lookupProjectID turns a project name into its ID. IDs are returned without an API call.
+func lookupProjectID(api_token string, ref string) (string, error) {
+	if ref == "" || uuidPattern.MatchString(ref) {
+		return ref, nil
+	}
+	projects, err := get_projects(api_token)
+	if err != nil {
+		return "", err
+	}
+	project, err := findProject(projects, ref)
+	if err != nil {
+		return "", err
+	}
+	return project.ID, nil
+}
+
+// defaultProjectRef returns OKAREO_PROJECT_ID or the project chosen with `okareo projects use`.
+func defaultProjectRef() string {
+	if ref := os.Getenv("OKAREO_PROJECT_ID"); ref != "" {
+		return ref
+	}
+	if creds, err := loadCredentials(); err == nil {
+		if account, ok := creds.Accounts[selectedAccount(creds)]; ok {
+			return account.ProjectID
+		}
+	}
+	return ""
+}
+
+// resolveProjectID returns the project ID for API commands from --project-id or the
+// default project, resolving names to IDs, and exits when none is available.
+func resolveProjectID(api_token string, value string) string {
+	if value == "" {
+		value = defaultProjectRef()
+	}
+	if value == "" {
+		fmt.Println("Error: A project is required. Use --project-id, set OKAREO_PROJECT_ID or run 'okareo projects use'.")
+		os.Exit(1)
+	}
+	project_id, err := lookupProjectID(api_token, value)
+	if err != nil {
+		fmt.Println("Error: Unable to resolve project '"+value+"'.", err)
+		os.Exit(1)
+	}
+	return project_id
+}
+
+func setDefaultProject(project *Project) {
+	creds, err := loadCredentials()
+	if err != nil {
+		fmt.Println("Error: Unable to read the credentials file.", err)
+		os.Exit(1)
+	}
+	name := selectedAccount(creds)
+	account, ok := creds.Accounts[name]
+	if !ok {
+		// keys from OKAREO_API_KEY have no stored account yet, so keep just the project
+		account = &Account{}
+		creds.Accounts[name] = account
+	}
+	account.ProjectID = project.ID
+	if creds.Current == "" {
+		creds.Current = name
+	}
+	if err := saveCredentials(creds); err != nil {
+		fmt.Println("Error: Unable to write the credentials file.", err)
+		os.Exit(1)
+	}
+	// stderr, so that -o json and -o yaml output stays parseable
+	fmt.Fprintln(os.Stderr, "Using project '"+project.Name+"' ("+project.ID+") for account '"+name+"'.")
+}
+
+func init() {
+	rootCmd.AddCommand(projectsCmd)
+	addOutputFlag(projectsCmd)
+	projectsCmd.PersistentFlags().BoolP("debug", "d", false, "See additional stdout to debug API calls.")
+
+	projectsCmd.AddCommand(projectsListCmd)
+
+	projectsCmd.AddCommand(projectsCreateCmd)
+	projectsCreateCmd.Flags().StringSliceP("tag", "t", nil, "A tag to attach to the project. Repeat for several tags.")
+	projectsCreateCmd.Flags().Bool("use", false, "Also make the new project the default project.")
+
+	projectsCmd.AddCommand(projectsUseCmd)
+}
@@ -0,0 +1,164 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ModelProvider describes where the key of one model type comes from.
+type ModelProvider struct {
+	Aliases  []string // other names for the provider in model-keys
+	EnvVars  []string // environment variables checked in order
+	Optional bool     // the model can run without a key, e.g. a custom endpoint
+}
+
+// the model types Okareo runs test runs for, keyed by the name in the model's "models"
+var modelProviders = map[string]ModelProvider{
+	"openai":          {EnvVars: []string{"OPENAI_API_KEY"}},
+	"azure_openai":    {Aliases: []string{"azure"}, EnvVars: []string{"AZURE_OPENAI_API_KEY", "AZURE_API_KEY"}},
+	"anthropic":       {Aliases: []string{"claude"}, EnvVars: []string{"ANTHROPIC_API_KEY"}},
+	"cohere":          {Aliases: []string{"co"}, EnvVars: []string{"COHERE_API_KEY", "CO_API_KEY"}},
+	"custom_endpoint": {Aliases: []string{"custom"}, EnvVars: []string{"CUSTOM_ENDPOINT_API_KEY"}, Optional: true},
+}
+
+// ModelKeys maps providers to API keys in config.yml. Values may use ${ENV}.
+//
+//	model-keys:
+//	  openai: ${OPENAI_API_KEY}
+//	  anthropic: ${ANTHROPIC_API_KEY}
+type ModelKeys map[string]string
+
+// UnmarshalYAML also reads the older "values:" nesting and the "-openai:" keys that
+// `okareo init` used to write.
+func (k *ModelKeys) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	raw := map[string]interface{}{}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	keys := ModelKeys{}
+	var add func(values map[string]interface{})
+	add = func(values map[string]interface{}) {
+		for name, value := range values {
+			switch value := value.(type) {
+			case string:
+				keys[strings.TrimPrefix(strings.ToLower(name), "-")] = value
+			case map[interface{}]interface{}:
+				if name == "values" {
+					nested := map[string]interface{}{}
+					for nested_name, nested_value := range value {
+						nested[fmt.Sprint(nested_name)] = nested_value
+					}
+					add(nested)
+				}
+			}
+		}
+	}
+	add(raw)
+	*k = keys
+	return nil
+}
+
+// ProviderKey is a resolved key for one model type, sent in the test run's api_keys.
+type ProviderKey struct {
+	Provider string // the model type, e.g. openai
+	Key      string
+	Source   string // where the key was found, for --dry-run and --debug
+}
+
+// resolveModelKeys finds a key for every model type of a model. Keys come from the
+// flow's model-keys, then config.yml model-keys, then the provider's environment
+// variables, then the model keys stored with `okareo login --model-key`.
+func resolveModelKeys(model *Model, flow_keys ModelKeys, config_keys ModelKeys) []ProviderKey {
+	model_types := []string{}
+	for model_type := range model.Models {
+		model_types = append(model_types, model_type)
+	}
+	sort.Strings(model_types)
+
+	var stored ModelKeys
+	if creds, err := loadCredentials(); err == nil {
+		if account, ok := creds.Accounts[selectedAccount(creds)]; ok {
+			stored = account.ModelKeys
+		}
+	}
+
+	keys := []ProviderKey{}
+	for _, model_type := range model_types {
+		provider := providerFor(model_type)
+		names := append([]string{model_type}, provider.Aliases...)
+		key := ProviderKey{Provider: model_type}
+		for _, source := range []struct {
+			name string
+			keys ModelKeys
+		}{{"flow model-keys", flow_keys}, {"config model-keys", config_keys}} {
+			if key.Key != "" {
+				break
+			}
+			for _, name := range names {
+				if value := tradeForEnvValue(source.keys[name]); value != "" {
+					key.Key, key.Source = value, source.name
+					break
+				}
+			}
+		}
+		for _, env := range provider.EnvVars {
+			if key.Key != "" {
+				break
+			}
+			if value := os.Getenv(env); value != "" {
+				key.Key, key.Source = value, env
+			}
+		}
+		for _, name := range names {
+			if key.Key != "" {
+				break
+			}
+			if value := stored[name]; value != "" {
+				key.Key, key.Source = value, "stored model key"
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// providerFor looks a model type up by name or alias. Unknown types read <TYPE>_API_KEY.
+func providerFor(model_type string) ModelProvider {
+	for name, provider := range modelProviders {
+		if name == model_type {
+			return provider
+		}
+		for _, alias := range provider.Aliases {
+			if alias == model_type {
+				provider.Aliases = append([]string{name}, provider.Aliases...)
+				return provider
+			}
+		}
+	}
+	return ModelProvider{EnvVars: []string{strings.ToUpper(model_type) + "_API_KEY"}}
+}
+
+// missingModelKeys names the model types that need a key but have none.
+func missingModelKeys(keys []ProviderKey) []string {
+	missing := []string{}
+	for _, key := range keys {
+		if key.Key == "" && !providerFor(key.Provider).Optional {
+			missing = append(missing, key.Provider)
+		}
+	}
+	return missing
+}
+
+// apiKeysMap is the api_keys object of a test run request.
+func apiKeysMap(keys []ProviderKey) map[string]string {
+	api_keys := map[string]string{}
+	for _, key := range keys {
+		api_keys[key.Provider] = key.Key
+	}
+	return api_keys
+}
@@ -0,0 +1,147 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResolveModelKeys(t *testing.T) {
+	creds_file := filepath.Join(t.TempDir(), "credentials.yml")
+	creds := `current: default
+accounts:
+  default:
+    api-key: k
+    model-keys:
+      anthropic: stored-anthropic
+      cohere: stored-cohere
+`
+	if err := os.WriteFile(creds_file, []byte(creds), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OKAREO_CREDENTIALS_FILE", creds_file)
+	t.Setenv("OKAREO_ACCOUNT", "")
+	for _, env := range []string{"OPENAI_API_KEY", "AZURE_OPENAI_API_KEY", "AZURE_API_KEY", "ANTHROPIC_API_KEY", "COHERE_API_KEY", "CO_API_KEY", "CUSTOM_ENDPOINT_API_KEY", "MISTRAL_API_KEY"} {
+		t.Setenv(env, "")
+	}
+
+	tests := []struct {
+		name        string
+		models      []string
+		env         map[string]string
+		flow_keys   ModelKeys
+		config_keys ModelKeys
+		want        []ProviderKey
+	}{
+		{
+			name:   "no key",
+			models: []string{"openai"},
+			want:   []ProviderKey{{Provider: "openai"}},
+		},
+		{
+			name:   "environment",
+			models: []string{"openai"},
+			env:    map[string]string{"OPENAI_API_KEY": "env-openai"},
+			want:   []ProviderKey{{Provider: "openai", Key: "env-openai", Source: "OPENAI_API_KEY"}},
+		},
+		{
+			name:   "second environment variable",
+			models: []string{"azure_openai"},
+			env:    map[string]string{"AZURE_API_KEY": "env-azure"},
+			want:   []ProviderKey{{Provider: "azure_openai", Key: "env-azure", Source: "AZURE_API_KEY"}},
+		},
+		{
+			name:        "config before environment",
+			models:      []string{"openai"},
+			env:         map[string]string{"OPENAI_API_KEY": "env-openai"},
+			config_keys: ModelKeys{"openai": "config-openai"},
+			want:        []ProviderKey{{Provider: "openai", Key: "config-openai", Source: "config model-keys"}},
+		},
+		{
+			name:        "flow before config",
+			models:      []string{"openai"},
+			flow_keys:   ModelKeys{"openai": "flow-openai"},
+			config_keys: ModelKeys{"openai": "config-openai"},
+			want:        []ProviderKey{{Provider: "openai", Key: "flow-openai", Source: "flow model-keys"}},
+		},
+		{
+			name:        "config value from the environment",
+			models:      []string{"openai"},
+			env:         map[string]string{"MY_OPENAI_KEY": "interpolated"},
+			config_keys: ModelKeys{"openai": "${MY_OPENAI_KEY}"},
+			want:        []ProviderKey{{Provider: "openai", Key: "interpolated", Source: "config model-keys"}},
+		},
+		{
+			name:        "empty interpolation falls through",
+			models:      []string{"openai"},
+			env:         map[string]string{"OPENAI_API_KEY": "env-openai"},
+			config_keys: ModelKeys{"openai": "${UNSET_OPENAI_KEY}"},
+			want:        []ProviderKey{{Provider: "openai", Key: "env-openai", Source: "OPENAI_API_KEY"}},
+		},
+		{
+			name:        "alias",
+			models:      []string{"anthropic"},
+			config_keys: ModelKeys{"claude": "config-claude"},
+			want:        []ProviderKey{{Provider: "anthropic", Key: "config-claude", Source: "config model-keys"}},
+		},
+		{
+			name:   "stored key",
+			models: []string{"anthropic"},
+			want:   []ProviderKey{{Provider: "anthropic", Key: "stored-anthropic", Source: "stored model key"}},
+		},
+		{
+			name:   "environment before stored key",
+			models: []string{"cohere"},
+			env:    map[string]string{"CO_API_KEY": "env-co"},
+			want:   []ProviderKey{{Provider: "cohere", Key: "env-co", Source: "CO_API_KEY"}},
+		},
+		{
+			name:   "unknown model type",
+			models: []string{"mistral"},
+			env:    map[string]string{"MISTRAL_API_KEY": "env-mistral"},
+			want:   []ProviderKey{{Provider: "mistral", Key: "env-mistral", Source: "MISTRAL_API_KEY"}},
+		},
+		{
+			name:        "several model types in order",
+			models:      []string{"openai", "anthropic"},
+			config_keys: ModelKeys{"openai": "config-openai"},
+			want: []ProviderKey{
+				{Provider: "anthropic", Key: "stored-anthropic", Source: "stored model key"},
+				{Provider: "openai", Key: "config-openai", Source: "config model-keys"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			model := &Model{Models: map[string]interface{}{}}
+			for _, model_type := range tt.models {
+				model.Models[model_type] = map[string]interface{}{}
+			}
+			got := resolveModelKeys(model, tt.flow_keys, tt.config_keys)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolveModelKeys() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMissingModelKeys(t *testing.T) {
+	keys := []ProviderKey{
+		{Provider: "openai", Key: "sk"},
+		{Provider: "anthropic"},
+		{Provider: "custom_endpoint"},
+		{Provider: "custom"},
+		{Provider: "mistral"},
+	}
+	want := []string{"anthropic", "mistral"}
+	if got := missingModelKeys(keys); !reflect.DeepEqual(got, want) {
+		t.Errorf("missingModelKeys() = %v, want %v", got, want)
+	}
+}
@@ -123,15 +123,21 @@ general_settings:
             }
         }
         env = filteredEnv
-		okareoApiKey := os.Getenv("OKAREO_API_KEY")
+		okareoApiKey, _ := resolveAPIKey(loadCommandConfig(cmd).APIKey)
 		dev, _ := cmd.Flags().GetBool("dev")
 
+		if dev && firstSetting("base-url", "OKAREO_BASE_URL", "") == "" {
+			// --dev is shorthand for --base-url http://localhost:8000
+			rootCmd.PersistentFlags().Set("base-url", "http://localhost:8000")
+		}
+
 		if okareoApiKey != "" {
-			if dev {
-				env = append(env, "OTEL_ENDPOINT=http://localhost:8000/v0/traces")
-			} else {
-				env = append(env, "OTEL_ENDPOINT=https://api.okareo.com/v0/traces")
+			env = append(env, endpointEnv()...)
+			if ca_bundle := get_ca_bundle(); ca_bundle != "" {
+				// the trace exporter only talks to Okareo, so it can trust the bundle alone
+				env = append(env, "OTEL_EXPORTER_OTLP_CERTIFICATE="+ca_bundle)
 			}
+			env = append(env, "OTEL_ENDPOINT="+get_endpoint()+"/v0/traces")
 			env = append(env, "OTEL_HEADERS=api-key="+okareoApiKey)
 			env = append(env, "OTEL_EXPORTER=otlp_http")
 		}
@@ -160,6 +166,6 @@ func init() {
 	proxyCmd.Flags().StringP("host", "H", "0.0.0.0", "Host to run the proxy server on")
 	proxyCmd.Flags().StringP("model", "m", "", "Model to use (e.g., gpt-3.5-turbo, claude-2)")
 	proxyCmd.Flags().BoolP("debug", "d", false, "Enable debug mode")
-	proxyCmd.Flags().BoolP("dev", "", false, "Use local development endpoint for traces")
+	proxyCmd.Flags().BoolP("dev", "", false, "Use the local development endpoint for traces. Shorthand for --base-url http://localhost:8000")
 	proxyCmd.Flags().StringP("config", "c", "", "Path to config file")
 }
\ No newline at end of file
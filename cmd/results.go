@@ -0,0 +1,198 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FlowOutput is the result file a script flow may write to OKAREO_JSON_OUTPUT_FILE.
+// The CLI reads it after the flow exits; a failed assertion fails the flow.
+//
+//	{
+//	  "test_runs": [{"id": "...", "name": "...", "app_link": "...", "metrics": {...}}],
+//	  "metrics": {"accuracy": 0.92},
+//	  "assertions": [{"name": "accuracy >= 0.9", "passed": true, "message": "..."}]
+//	}
+//
+// A single test run object, or an array of them, as returned by the Okareo SDKs is
+// also accepted.
+type FlowOutput struct {
+	TestRuns   []FlowTestRun          `json:"test_runs"`
+	Metrics    map[string]interface{} `json:"metrics,omitempty"`
+	Assertions []FlowAssertion        `json:"assertions,omitempty"`
+}
+
+type FlowTestRun struct {
+	ID           string                 `json:"id"`
+	Name         string                 `json:"name,omitempty"`
+	AppLink      string                 `json:"app_link,omitempty"`
+	Metrics      map[string]interface{} `json:"metrics,omitempty"`
+	ModelMetrics map[string]interface{} `json:"model_metrics,omitempty"`
+}
+
+type FlowAssertion struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+// readFlowOutput reads a flow's result file. A flow that wrote none returns nil.
+func readFlowOutput(path string) (*FlowOutput, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	output := &FlowOutput{}
+	switch {
+	case data[0] == '[':
+		err = json.Unmarshal(data, &output.TestRuns)
+	default:
+		var fields map[string]json.RawMessage
+		if err = json.Unmarshal(data, &fields); err != nil {
+			break
+		}
+		if _, ok := fields["test_runs"]; !ok && fields["id"] != nil {
+			test_run := FlowTestRun{}
+			err = json.Unmarshal(data, &test_run)
+			output.TestRuns = []FlowTestRun{test_run}
+		} else {
+			err = json.Unmarshal(data, output)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s does not follow the result file format: %v", path, err)
+	}
+	for i := range output.TestRuns {
+		if output.TestRuns[i].Metrics == nil {
+			output.TestRuns[i].Metrics = output.TestRuns[i].ModelMetrics
+		}
+		output.TestRuns[i].ModelMetrics = nil
+	}
+	return output, nil
+}
+
+// failedAssertions returns the assertions of a flow that did not pass.
+func failedAssertions(assertions []FlowAssertion) []FlowAssertion {
+	failed := []FlowAssertion{}
+	for _, assertion := range assertions {
+		if !assertion.Passed {
+			failed = append(failed, assertion)
+		}
+	}
+	return failed
+}
+
+// RunResults is written to <reports>/results.json at the end of `okareo run`, combining
+// config flows and the result files of script flows.
+type RunResults struct {
+	Run      string                 `json:"run"`
+	Finished string                 `json:"finished"`
+	Summary  *RunSummary            `json:"summary"`
+	Groups   map[string]*RunSummary `json:"groups,omitempty"` // by folder of .okareo/flows, "" for the top level
+	Flows    []FlowResultJSON       `json:"flows"`
+}
+
+type FlowResultJSON struct {
+	Name       string                 `json:"name"`
+	Group      string                 `json:"group,omitempty"`
+	Runner     string                 `json:"runner"`
+	Status     string                 `json:"status"`
+	DurationMs int64                  `json:"duration_ms"`
+	Retries    int                    `json:"retries"`
+	TestRuns   []FlowTestRun          `json:"test_runs,omitempty"`
+	Metrics    map[string]interface{} `json:"metrics,omitempty"`
+	Assertions []FlowAssertion        `json:"assertions,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	Matrix     *MatrixCell            `json:"matrix,omitempty"`
+}
+
+func flowResultJSON(result FlowResult) FlowResultJSON {
+	return FlowResultJSON{
+		Name:       result.Name,
+		Group:      result.Group,
+		Runner:     result.Runner,
+		Status:     result.Status,
+		DurationMs: result.Duration.Milliseconds(),
+		Retries:    result.Retries,
+		TestRuns:   result.TestRuns,
+		Metrics:    result.Metrics,
+		Assertions: result.Assertions,
+		Error:      result.Error,
+		Matrix:     result.Matrix,
+	}
+}
+
+func summarizeResults(results []FlowResult) *RunSummary {
+	summary := &RunSummary{Total: len(results)}
+	for _, result := range results {
+		summary.Retries += result.Retries
+		switch result.Status {
+		case flowPassed:
+			summary.Passed++
+		case flowFailed:
+			summary.Failed++
+		case flowTimedOut:
+			summary.TimedOut++
+		case flowCancelled:
+			summary.Cancelled++
+		}
+	}
+	return summary
+}
+
+// groupResults summarizes results by the folder of their flows. It returns nil when
+// every flow sits at the top level of .okareo/flows.
+func groupResults(results []FlowResult) map[string]*RunSummary {
+	by_group := map[string][]FlowResult{}
+	for _, result := range results {
+		by_group[result.Group] = append(by_group[result.Group], result)
+	}
+	if len(by_group) < 2 {
+		return nil
+	}
+	groups := map[string]*RunSummary{}
+	for group, group_results := range by_group {
+		groups[group] = summarizeResults(group_results)
+	}
+	return groups
+}
+
+func groupLabel(group string) string {
+	if group == "" {
+		return "(top level)"
+	}
+	return group + "/"
+}
+
+func writeRunResults(reports_dir_path string, run_name string, results []FlowResult) error {
+	run_results := RunResults{
+		Run:      run_name,
+		Finished: time.Now().UTC().Format(time.RFC3339),
+		Summary:  summarizeResults(results),
+		Groups:   groupResults(results),
+		Flows:    []FlowResultJSON{},
+	}
+	for _, result := range results {
+		run_results.Flows = append(run_results.Flows, flowResultJSON(result))
+	}
+	data, err := json.MarshalIndent(run_results, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(reports_dir_path, "results.json"), data, 0644)
+}
@@ -0,0 +1,70 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadFlowOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		missing bool
+		want    *FlowOutput
+		wantErr bool
+	}{
+		{name: "no file", missing: true, want: nil},
+		{name: "empty file", content: " \n", want: nil},
+		{
+			name:    "result file",
+			content: `{"test_runs": [{"id": "t1", "name": "run", "metrics": {"accuracy": 0.9}}], "metrics": {"accuracy": 0.9}, "assertions": [{"name": "accuracy", "passed": true}]}`,
+			want: &FlowOutput{
+				TestRuns:   []FlowTestRun{{ID: "t1", Name: "run", Metrics: map[string]interface{}{"accuracy": 0.9}}},
+				Metrics:    map[string]interface{}{"accuracy": 0.9},
+				Assertions: []FlowAssertion{{Name: "accuracy", Passed: true}},
+			},
+		},
+		{
+			name:    "test run object from an SDK",
+			content: `{"id": "t1", "name": "run", "app_link": "https://app/t1", "model_metrics": {"mean_scores": {"fluency": 4}}}`,
+			want: &FlowOutput{TestRuns: []FlowTestRun{{
+				ID: "t1", Name: "run", AppLink: "https://app/t1",
+				Metrics: map[string]interface{}{"mean_scores": map[string]interface{}{"fluency": 4.0}},
+			}}},
+		},
+		{
+			name:    "array of test runs",
+			content: `[{"id": "t1"}, {"id": "t2", "metrics": {"f1": 0.5}}]`,
+			want:    &FlowOutput{TestRuns: []FlowTestRun{{ID: "t1"}, {ID: "t2", Metrics: map[string]interface{}{"f1": 0.5}}}},
+		},
+		{
+			name:    "assertions only",
+			content: `{"assertions": [{"name": "latency", "passed": false, "message": "900ms"}]}`,
+			want:    &FlowOutput{Assertions: []FlowAssertion{{Name: "latency", Passed: false, Message: "900ms"}}},
+		},
+		{name: "not json", content: "done", wantErr: true},
+		{name: "wrong types", content: `{"assertions": [{"name": "x", "passed": "yes"}]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "flow.result.json")
+			if !tt.missing {
+				if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := readFlowOutput(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readFlowOutput() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readFlowOutput() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -55,6 +55,11 @@ func init() {
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 	rootCmd.PersistentFlags().BoolP("version", "v", true, "The current version of the Okareo CLI")
+	rootCmd.PersistentFlags().String("base-url", "", "The Okareo API base URL for self-hosted deployments. Defaults to OKAREO_BASE_URL, 'base-url' in config.yml or "+defaultBaseURL+".")
+	rootCmd.PersistentFlags().String("ca-bundle", "", "A PEM file of additional CA certificates to trust. Defaults to OKAREO_CA_BUNDLE or 'ca-bundle' in config.yml.")
+	rootCmd.PersistentFlags().String("proxy", "", "The HTTP(S) proxy URL for Okareo API calls. Defaults to OKAREO_PROXY, 'proxy' in config.yml or HTTPS_PROXY.")
+	rootCmd.PersistentFlags().String("workspace", "", "The folder that holds the .okareo directory. Defaults to the nearest folder with one, looking upward from the working directory.")
+	rootCmd.PersistentFlags().String("account", "", "The stored Okareo account to use. Defaults to OKAREO_ACCOUNT or the last account used with 'okareo login'.")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -5,14 +5,17 @@ package cmd
 
 import (
 	"bufio"
-	"crypto/rand"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
-	"regexp"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 
@@ -24,28 +27,37 @@ import (
 )
 
 type FlowConfig struct {
-	Name        string   `yaml:"name"`
-	Project_id  string   `yaml:"project-id"`
-	Model_id    string   `yaml:"model-id"`
-	Scenario_id string   `yaml:"scenario-id"`
-	Type        string   `yaml:"type"`
-	Checks      []string `yaml:"checks"`
+	Name        string      `yaml:"name"`
+	Project_id  string      `yaml:"project-id"`
+	Model_id    string      `yaml:"model-id"`
+	Scenario_id string      `yaml:"scenario-id"`
+	Type        string      `yaml:"type"`
+	Checks      []string    `yaml:"checks"`
+	Timeout     string      `yaml:"timeout"`
+	Tags        []string    `yaml:"tags"`
+	ModelKeys   ModelKeys   `yaml:"model-keys"` // per flow, ahead of the config model-keys
+	OpenAIKey   string      `yaml:"openai-key"`
+	Matrix      *FlowMatrix `yaml:"matrix"`
+	Cell        *MatrixCell `yaml:"-"` // set on the flows a matrix expands into
 }
 
-type ModelValues map[string]string // map of model keys to model ids
-
 type Config struct {
-	Name      string
-	APIKey    string `yaml:"api-key"`
-	ProjectID string `yaml:"project-id"`
-	Language  string `yaml:"language"`
-	ModelKeys struct {
-		Values ModelValues `yaml:"values"`
-	}
-	Run struct {
+	Name           string
+	RunName        string `yaml:"run-name"` // template for the run name, see RunNameData
+	APIKey         string `yaml:"api-key"`
+	ProjectID      string `yaml:"project-id"`
+	Language       string `yaml:"language"`
+	EndpointConfig `yaml:",inline"`
+	ModelKeys      ModelKeys `yaml:"model-keys"`
+	Run            struct {
 		Flows struct {
-			FilePattern string        `yaml:"file-pattern"`
-			FlowConfigs []*FlowConfig `yaml:"configs"`
+			FilePattern string                       `yaml:"file-pattern"`
+			Languages   FlowGlobs[string]            `yaml:"languages"` // file name glob -> language override
+			Runners     []RunnerConfig               `yaml:"runners"`
+			Timeouts    FlowGlobs[string]            `yaml:"timeouts"`   // file name glob -> timeout
+			Retries     FlowGlobs[int]               `yaml:"retries"`    // file name glob -> retries after a non-zero exit
+			Thresholds  FlowGlobs[map[string]string] `yaml:"thresholds"` // flow glob -> metric -> condition, see Threshold
+			FlowConfigs []*FlowConfig                `yaml:"configs"`
 		}
 	}
 }
@@ -84,12 +96,17 @@ func check(e error) {
 	}
 }
 
-func get_endpoint() string {
-	endpoint := os.Getenv("OKAREO_BASE_URL")
-	if endpoint == "" {
-		return "https://api.okareo.com"
-	}
-	return endpoint
+// RunPlan is what `okareo run` resolved from config.yml and its flags before running flows.
+type RunPlan struct {
+	Config      Config
+	ConfigFile  string
+	Filter      FlowFilter
+	ConfigFlows []*FlowConfig
+	Flows       []FlowFile
+	Runners     []FlowRunner
+	Context     *RunContext
+	Timeout     time.Duration
+	Retries     int
 }
 
 var runCmd = &cobra.Command{
@@ -98,304 +115,514 @@ var runCmd = &cobra.Command{
 	Long:  `Okareo CLI 'runs' can include multiple flows that perform a variety of tasks from scenario generation to model evaluation.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		isDebug, _ := cmd.Flags().GetBool("debug")
-		flowFileFlag, _ := cmd.Flags().GetString("file")
-		configFileFlag, _ := cmd.Flags().GetString("config")
-		reports_dir_path, _ := cmd.Flags().GetString("reports")
-		outputFile, _ := cmd.Flags().GetString("outputFile")
-
-		config_file, read_err := os.ReadFile(configFileFlag)
-		check(read_err)
+		listFlows, _ := cmd.Flags().GetBool("list")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		watch, _ := cmd.Flags().GetBool("watch")
+		api_retries, _ = cmd.Flags().GetInt("api-retries")
+		outputFormat, _ := cmd.Flags().GetString("output")
 
-		if (reports_dir_path != "") && (!strings.HasPrefix(reports_dir_path, "/")) {
-			reports_dir_path = "./.okareo/" + reports_dir_path + "/"
+		reporter, err := newRunReporter(outputFormat, os.Stdout)
+		if err != nil {
+			fmt.Fprintln(runOutput, "Error:", err)
+			os.Exit(1)
+		}
+		if reporter.structured() {
+			// keep stdout for the events; everything else the run prints goes to stderr
+			runOutput = os.Stderr
+		}
+		if watch && reporter.Format == "json" {
+			// each re-run would print another JSON array; ndjson streams the events instead
+			reporter.Fatal("Error: --output json can't be combined with --watch. Use --output ndjson.", nil)
 		}
-		prepare_reports_dir(reports_dir_path, isDebug)
 
-		config := Config{}
-		err := yaml.Unmarshal([]byte(config_file), &config)
+		allWorkspaces, _ := cmd.Flags().GetBool("all-workspaces")
+		if allWorkspaces {
+			runAllWorkspaces(cmd, reporter)
+			return
+		}
+		if err := enterWorkspace(cmd, true, "config", "outputFile"); err != nil {
+			reporter.Fatal("Error:", err)
+		}
+		plan, err := planRun(cmd)
 		if err != nil {
-			// make errors topical and friendly
-			log.Fatalf("error: %v", err)
+			reporter.Fatal("Error:", err)
+		}
+		if plan == nil {
 			return
 		}
-
-		n := 5
-		b := make([]byte, n)
-		if _, err := rand.Read(b); err != nil {
-			panic(err)
-		}
-		s := fmt.Sprintf("%X", b)
-		var run_name string = config.Name + "-" + s
-
-		var flows_folder string = "./.okareo/flows/"
-		var filePattern string = config.Run.Flows.FilePattern
-		var language string = config.Language
-		var okareoAPIKey string = tradeForEnvValue(config.APIKey)
-		var projectId string = tradeForEnvValue(config.ProjectID)
-		var runScripts bool = false
-		var runConfigFlows bool = (len(config.Run.Flows.FlowConfigs) > 0)
-		if language != "" {
-			if strings.ToLower(language) == "python" || strings.ToLower(language) == "py" {
-				language = "python"
-				runScripts = true
-			} else if strings.ToLower(language) == "typescript" || strings.ToLower(language) == "ts" {
-				language = "typescript"
-				runScripts = true
-			} else if strings.ToLower(language) == "javascript" || strings.ToLower(language) == "js" {
-				language = "javascript"
-				runScripts = true
+		if listFlows {
+			printFlowList(plan.ConfigFlows, plan.Flows)
+			return
+		}
+		if dryRun {
+			if printDryRun(plan.Context, plan.Config, plan.ConfigFlows, plan.Flows, plan.Runners, plan.Timeout, plan.Retries) > 0 {
+				os.Exit(1)
 			}
+			return
 		}
 
-		if !runConfigFlows && !runScripts {
-			fmt.Println("No flows or scripts to run.")
+		// Ctrl-C stops the running flow and skips the rest, then prints the summary
+		run_ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		if err := prepare_reports_dir(plan.Context.ReportsDirPath, isDebug); err != nil {
+			reporter.Fatal("Error: Unable to create the reports folder.", err)
+		}
+		if watch {
+			watchRun(run_ctx, cmd, reporter, plan)
 			return
 		}
 
-		if runConfigFlows {
-			for i := 0; i < len(config.Run.Flows.FlowConfigs); i++ {
-				fmt.Println("Running flow: " + config.Run.Flows.FlowConfigs[i].Name)
-				model := get_model(okareoAPIKey, config.Run.Flows.FlowConfigs[i].Name, config.Run.Flows.FlowConfigs[i].Model_id, isDebug)
-				model_type := ""
-				for model_type = range model.Models {
-					break // just pulling the key to see what kind of model this is
-				}
-				model_key := ""
-				if model_type == "openai" {
-					model_key = os.Getenv("OPENAI_API_KEY")
-				}
-				project_id := model.ProjectID
-				config.Run.Flows.FlowConfigs[i].Project_id = project_id
-				testrun := run_config_test(okareoAPIKey, model_type, model_key, config.Run.Flows.FlowConfigs[i], reports_dir_path, isDebug)
-
-				if (testrun.Name == "") || (testrun.ID == "") {
-					fmt.Println("Error: Test run failed. Likely due to missing or incorrect test type or scenario id.")
-					os.Exit(0)
-				}
-				fmt.Println("Completed: " + testrun.Name)
-				fmt.Println("ID: " + testrun.ID)
-				fmt.Println("Link: " + testrun.AppLink)
-				fmt.Println("-----")
+		results := executeRun(run_ctx, reporter, plan)
+		if plan.Context.ReportsDirPath != "" && len(results) > 0 {
+			if err := writeRunResults(plan.Context.ReportsDirPath, plan.Context.RunName, results); err != nil {
+				fmt.Fprintln(runOutput, "Warning: Unable to write the run results.", err)
 			}
 		}
-
-		if runScripts {
-			if strings.ToLower(language) == "python" || strings.ToLower(language) == "py" {
-				// this is done everytime because the requirements.txt file could change
-				installOkareoPython(isDebug)
-				//installOkareoPythonCMD(isDebug)
-				entries, err := os.ReadDir(flows_folder)
-				if err != nil {
-					if isDebug {
-						fmt.Println("Debug: Flows folder not found.")
-					}
-					log.Fatal(err)
-				}
-
-				var foundFlow bool = false
-				for _, e := range entries {
-					if flowFileFlag != "ALL" {
-						isFile, _ := regexp.MatchString(flowFileFlag+"(.py)", e.Name())
-						if isFile {
-							if isDebug {
-								fmt.Println("Match file:", e.Name())
-							}
-							foundFlow = true
-							doPythonScript(flows_folder+e.Name(), okareoAPIKey, projectId, run_name, outputFile, reports_dir_path, isDebug)
-						}
-					} else {
-						match, _ := regexp.MatchString(filePattern+"$", e.Name())
-						if isDebug {
-							fmt.Println("Match file:", e.Name(), match)
-						}
-						if match {
-							fmt.Println("Running .okareo/flows/" + e.Name())
-							doPythonScript(flows_folder+e.Name(), okareoAPIKey, projectId, run_name, outputFile, reports_dir_path, isDebug)
-						}
-					}
-				}
-				if flowFileFlag != "ALL" && !foundFlow {
-					fmt.Println("Flow not found: " + flowFileFlag)
-				}
-				//}
-			} else if strings.ToLower(language) == "ts" || strings.ToLower(language) == "typescript" {
-				installOkareoTypescript(isDebug)
-				doTSBuild(isDebug)
-				var dist_folder string = "./.okareo/dist/"
-
-				entries, err := os.ReadDir(flows_folder)
-				if err != nil {
-					log.Fatal(err)
-				}
-				var foundFlow bool = false
-				for _, e := range entries {
-					if flowFileFlag != "ALL" {
-						isFile, _ := regexp.MatchString(flowFileFlag+"(.ts)", e.Name())
-						if isFile {
-							if isDebug {
-								fmt.Println("Match file:", e.Name())
-							}
-							foundFlow = true
-							var distFile string = strings.Split(e.Name(), ".")[0] + ".js"
-							doJSScript(dist_folder+distFile, okareoAPIKey, projectId, run_name, outputFile, reports_dir_path, isDebug)
-						}
-					} else {
-						match, _ := regexp.MatchString(filePattern+"$", e.Name())
-						var distFile string = strings.Split(e.Name(), ".")[0] + ".js"
-						if isDebug {
-							fmt.Println("Match file:", e.Name(), match)
-						}
-						if match {
-							fmt.Println("Running .okareo/flows/" + e.Name())
-							doJSScript(dist_folder+distFile, okareoAPIKey, projectId, run_name, outputFile, reports_dir_path, isDebug)
-						}
-					}
-				}
-
-				if flowFileFlag != "ALL" && !foundFlow {
-					fmt.Println("Flow not found: " + flowFileFlag)
-				}
-
-			} else if strings.ToLower(language) == "js" || strings.ToLower(language) == "javascript" {
-				installOkareoJavascript(isDebug)
-				entries, err := os.ReadDir(flows_folder)
-				if err != nil {
-					log.Fatal(err)
-				}
-
-				var foundFlow bool = false
-				for _, e := range entries {
-					if flowFileFlag != "ALL" {
-						isFile, _ := regexp.MatchString(flowFileFlag+"(.js)", e.Name())
-						if isFile {
-							if isDebug {
-								fmt.Println("Match file:", e.Name())
-							}
-							foundFlow = true
-							doJSScript(flows_folder+e.Name(), okareoAPIKey, projectId, run_name, outputFile, reports_dir_path, isDebug)
-						}
-					} else {
-						match, _ := regexp.MatchString(filePattern+"$", e.Name())
-						if isDebug {
-							fmt.Println("Match file:", e.Name(), match)
-						}
-						if match {
-							fmt.Println("Running .okareo/flows/" + e.Name())
-							doJSScript(flows_folder+e.Name(), okareoAPIKey, projectId, run_name, outputFile, reports_dir_path, isDebug)
-						}
-					}
-				}
-				if flowFileFlag != "ALL" && !foundFlow {
-					fmt.Println("Flow not found: " + flowFileFlag)
-				}
-			} else {
-				fmt.Println("Language not supported.")
+		if len(results) == 0 {
+			return
+		}
+		passed := printRunSummary(results)
+		printMatrixComparison(results)
+		if !passed {
+			if run_ctx.Err() != nil {
+				os.Exit(130)
 			}
+			os.Exit(1)
 		}
 	},
 }
 
-func prepare_reports_dir(reports_dir_path string, isDebug bool) {
+// planRun reads config.yml and the run flags, resolves the API key and project and
+// selects the flows to run. It returns a nil plan when there is nothing to run.
+func planRun(cmd *cobra.Command) (*RunPlan, error) {
+	isDebug, _ := cmd.Flags().GetBool("debug")
+	flowFlags, _ := cmd.Flags().GetStringSlice("flow")
+	fileFlags, _ := cmd.Flags().GetStringSlice("file")
+	excludeFlags, _ := cmd.Flags().GetStringSlice("exclude")
+	tagFlags, _ := cmd.Flags().GetStringSlice("tag")
+	runTagFlags, _ := cmd.Flags().GetStringSlice("run-tag")
+	runNameFlag, _ := cmd.Flags().GetString("run-name")
+	configFileFlag, _ := cmd.Flags().GetString("config")
+	reports_dir_path, _ := cmd.Flags().GetString("reports")
+	outputFile, _ := cmd.Flags().GetString("outputFile")
+	reinstall, _ := cmd.Flags().GetBool("reinstall")
+	offline, _ := cmd.Flags().GetBool("offline")
+	watch, _ := cmd.Flags().GetBool("watch")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	retries, _ := cmd.Flags().GetInt("retries")
+
+	config_file, err := os.ReadFile(configFileFlag)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the config file: %v", err)
+	}
+
+	if (reports_dir_path != "") && (!strings.HasPrefix(reports_dir_path, "/")) {
+		reports_dir_path = "./.okareo/" + reports_dir_path + "/"
+	}
+
+	config := Config{}
+	if err := yaml.Unmarshal([]byte(config_file), &config); err != nil {
+		// make errors topical and friendly
+		return nil, fmt.Errorf("invalid config file %s: %v", configFileFlag, err)
+	}
+	setConfigEndpoint(config.EndpointConfig, ".")
+	for _, glob := range config.Run.Flows.Timeouts {
+		if _, err := parseTimeout(glob.Value); err != nil {
+			return nil, fmt.Errorf("invalid timeout for flows matching '%s': %v", glob.Pattern, err)
+		}
+	}
+	for _, glob := range config.Run.Flows.Thresholds {
+		if _, err := flowThresholds(glob.Pattern, FlowGlobs[map[string]string]{glob}); err != nil {
+			return nil, fmt.Errorf("invalid thresholds for flows matching '%s': %v", glob.Pattern, err)
+		}
+	}
+
+	metadata := detectRunMetadata()
+	if runNameFlag != "" {
+		config.RunName = runNameFlag
+	}
+	run_name, err := runName(config.RunName, config.Name, metadata)
+	if err != nil {
+		return nil, fmt.Errorf("invalid run-name template: %v", err)
+	}
+
+	okareoAPIKey, _ := resolveAPIKey(config.APIKey)
+	project_ref := tradeForEnvValue(config.ProjectID)
+	if project_ref == "" {
+		project_ref = defaultProjectRef()
+	}
+	projectId, err := lookupProjectID(okareoAPIKey, project_ref)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve project '%s': %v", project_ref, err)
+	}
+	runners, err := flowRunners(config.Run.Flows.Runners)
+	if err != nil {
+		return nil, err
+	}
+	if config.Language != "" && normalizeLanguage(config.Language, runners) == "" {
+		return nil, fmt.Errorf("language not supported: %s. Use python, typescript, javascript, go, auto or the name of a custom runner", config.Language)
+	}
+	if len(config.Run.Flows.FlowConfigs) == 0 && config.Language == "" {
+		fmt.Fprintln(runOutput, "No flows or scripts to run.")
+		return nil, nil
+	}
+
+	plan := &RunPlan{
+		Config:     config,
+		ConfigFile: configFileFlag,
+		Filter:     FlowFilter{Exclude: excludeFlags, Tags: tagFlags},
+		Runners:    runners,
+		Context: &RunContext{
+			OkareoAPIKey:   okareoAPIKey,
+			ProjectID:      projectId,
+			RunName:        run_name,
+			OutputFile:     outputFile,
+			ReportsDirPath: reports_dir_path,
+			Reinstall:      reinstall,
+			Offline:        offline,
+			Incremental:    watch,
+			Tags:           runTagFlags,
+			Metadata:       metadata,
+			IsDebug:        isDebug,
+		},
+		Timeout: timeout,
+		Retries: retries,
+	}
+	for _, pattern := range append(flowFlags, fileFlags...) {
+		if pattern != "ALL" {
+			plan.Filter.Include = append(plan.Filter.Include, pattern)
+		}
+	}
+	config_flows, err := expandMatrixFlows(config.Run.Flows.FlowConfigs)
+	if err != nil {
+		return nil, err
+	}
+	for _, flow := range config_flows {
+		if plan.Filter.Match(flow.selectName(), flow.Tags) {
+			plan.ConfigFlows = append(plan.ConfigFlows, flow)
+		}
+	}
+	plan.Flows, err = selectFlowFiles(plan)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkOutputFile(plan.Context, plan.Flows); err != nil {
+		return nil, err
+	}
+	if plan.Filter.Selective() && len(plan.ConfigFlows) == 0 && len(plan.Flows) == 0 && !watch {
+		fmt.Fprintln(runOutput, "Flow not found: "+strings.Join(append(plan.Filter.Include, plan.Filter.Tags...), ", "))
+		return nil, nil
+	}
+	return plan, nil
+}
+
+// checkOutputFile rejects --outputFile when several script flows would share the file.
+func checkOutputFile(ctx *RunContext, flows []FlowFile) error {
+	if ctx.OutputFile != "" && len(flows) > 1 {
+		return fmt.Errorf("--outputFile names the result file of one script flow, but %d are selected. Select one with --flow, or leave --outputFile out to get <reports>/<flow>.result.json for each flow", len(flows))
+	}
+	return nil
+}
+
+// selectFlowFiles discovers the script flows of the configured language that pass the filter.
+func selectFlowFiles(plan *RunPlan) ([]FlowFile, error) {
+	var flows_folder string = "./.okareo/flows/"
+	language := normalizeLanguage(plan.Config.Language, plan.Runners)
+	flows := []FlowFile{}
+	if language == "" {
+		return flows, nil
+	}
+	file_pattern, err := compileFilePattern(plan.Config.Run.Flows.FilePattern)
+	if err != nil {
+		return nil, err
+	}
+	discovered, err := discoverFlowFiles(flows_folder, plan.Config.Run.Flows.Languages, plan.Runners, plan.Context.IsDebug)
+	if err != nil {
+		return nil, err
+	}
+	for _, flow := range discovered {
+		// "auto" runs the flows of every detected runner side by side
+		if language != "auto" && flow.Language != language {
+			continue
+		}
+		match := plan.Filter.Match(flow.Name, flow.Tags)
+		if len(plan.Filter.Include) == 0 {
+			match = match && file_pattern.MatchString(flow.Name)
+		}
+		if plan.Context.IsDebug {
+			fmt.Fprintln(runOutput, "Match file:", flow.Name, flow.Language, match)
+		}
+		if match {
+			flows = append(flows, flow)
+		}
+	}
+	return flows, nil
+}
+
+// executeRun runs the config flows and then the script flows of a plan.
+func executeRun(run_ctx context.Context, reporter *RunReporter, plan *RunPlan) []FlowResult {
+	ctx := plan.Context
+	okareoAPIKey := ctx.OkareoAPIKey
+	reports_dir_path := ctx.ReportsDirPath
+	isDebug := ctx.IsDebug
+	reporter.RunName = ctx.RunName
+	reporter.RunStarted()
+
+	results := []FlowResult{}
+	finish := func(result FlowResult) {
+		checkThresholds(&result, plan.Config.Run.Flows.Thresholds)
+		results = append(results, result)
+		reporter.FlowFinished(result)
+	}
+
+	config_flows := plan.ConfigFlows
+	for i := 0; i < len(config_flows); i++ {
+		if run_ctx.Err() != nil {
+			finish(FlowResult{Name: config_flows[i].Name, Runner: "config", Status: flowCancelled})
+			continue
+		}
+		fmt.Fprintln(runOutput, "Running flow: "+config_flows[i].Name)
+		reporter.FlowStarted(config_flows[i].Name, "config")
+		start := time.Now()
+		flow_timeout, err := parseTimeout(config_flows[i].Timeout)
+		if err != nil {
+			fmt.Fprintln(runOutput, "Error: Invalid timeout for flow '"+config_flows[i].Name+"'.", err)
+			finish(FlowResult{Name: config_flows[i].Name, Runner: "config", Status: flowFailed, Error: err.Error(), Matrix: config_flows[i].Cell})
+			continue
+		}
+		if flow_timeout == 0 {
+			flow_timeout = plan.Timeout
+		}
+		model, model_keys, model_retries, err := prepareConfigFlow(okareoAPIKey, ctx.ProjectID, config_flows[i], plan.Config.ModelKeys, isDebug)
+		if err != nil {
+			// a bad model fails its flow, or its cell of a matrix, and the run goes on
+			fmt.Fprintln(runOutput, "Error:", err)
+			finish(FlowResult{Name: config_flows[i].Name, Runner: "config", Status: flowFailed, Duration: time.Since(start), Retries: model_retries, Error: err.Error(), Matrix: config_flows[i].Cell})
+			continue
+		}
+		if config_flows[i].Cell != nil {
+			config_flows[i].Cell.ModelName = model.Name
+		}
+
+		flow_ctx, cancel := flowContext(run_ctx, flow_timeout)
+		testrun, retries, err := run_config_test(flow_ctx, okareoAPIKey, model_keys, config_flows[i], testRunTags(ctx, config_flows[i].Tags), reports_dir_path, isDebug)
+		result := FlowResult{Name: config_flows[i].Name, Runner: "config", Status: flowStatus(flow_ctx, err), Duration: time.Since(start), Retries: model_retries + retries, Matrix: config_flows[i].Cell}
+		cancel()
+		if err == nil && ((testrun.Name == "") || (testrun.ID == "")) {
+			result.Status = flowFailed
+			err = fmt.Errorf("likely due to missing or incorrect test type or scenario id")
+		}
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.TestRuns = []FlowTestRun{{ID: testrun.ID, Name: testrun.Name, AppLink: testrun.AppLink, Metrics: testrun.ModelMetrics}}
+			result.Metrics = testrun.ModelMetrics
+		}
+		finish(result)
+		switch result.Status {
+		case flowTimedOut:
+			fmt.Fprintln(runOutput, "Error: Test run timed out after "+flow_timeout.String()+".")
+			continue
+		case flowCancelled:
+			fmt.Fprintln(runOutput, "Error: Test run was cancelled.")
+			continue
+		case flowFailed:
+			fmt.Fprintln(runOutput, "Error: Test run failed.", err)
+			continue
+		}
+		fmt.Fprintln(runOutput, "Completed: "+testrun.Name)
+		fmt.Fprintln(runOutput, "ID: "+testrun.ID)
+		fmt.Fprintln(runOutput, "Link: "+testrun.AppLink)
+		fmt.Fprintln(runOutput, "-----")
+	}
+
+	// install and build each runner once, in runner order. The flows of a runner that
+	// fails to install or build fail without running.
+	runner_errors := map[string]error{}
+	for _, runner := range plan.Runners {
+		runner_flows := []FlowFile{}
+		for _, flow := range plan.Flows {
+			if flow.Language == runner.Name() {
+				runner_flows = append(runner_flows, flow)
+			}
+		}
+		if len(runner_flows) == 0 || run_ctx.Err() != nil {
+			continue
+		}
+		if err := runner.Install(ctx); err != nil {
+			runner_errors[runner.Name()] = fmt.Errorf("unable to install the %s flow dependencies: %v", runner.Name(), err)
+		} else if err := runner.Build(ctx, runner_flows); err != nil {
+			runner_errors[runner.Name()] = fmt.Errorf("unable to build the %s flows: %v", runner.Name(), err)
+		}
+		if err := runner_errors[runner.Name()]; err != nil {
+			fmt.Fprintln(runOutput, "Error:", err)
+		}
+	}
+
+	for _, flow := range plan.Flows {
+		if run_ctx.Err() != nil {
+			finish(FlowResult{Name: flow.Name, Group: flow.Group, Runner: flow.Language, Status: flowCancelled})
+			continue
+		}
+		fmt.Fprintln(runOutput, "Running .okareo/flows/"+flow.Name)
+		reporter.FlowStarted(flow.Name, flow.Language)
+		runner := findRunner(plan.Runners, flow.Language)
+		err := runner_errors[flow.Language]
+		var script ScriptFlow
+		if err == nil {
+			script, err = scriptFlow(ctx, runner, flow, plan.Config, plan.Timeout, plan.Retries)
+		}
+		if err != nil {
+			fmt.Fprintln(runOutput, "Error: "+flow.Name+" failed.", err)
+			finish(FlowResult{Name: flow.Name, Group: flow.Group, Runner: flow.Language, Status: flowFailed, Error: err.Error()})
+			continue
+		}
+		finish(runFlowScript(run_ctx, reporter, script, reports_dir_path, isDebug))
+	}
+
+	reporter.RunFinished(results)
+	return results
+}
+
+func prepare_reports_dir(reports_dir_path string, isDebug bool) error {
 	if dirExists(reports_dir_path) {
 		d_err := os.RemoveAll(reports_dir_path)
 		if d_err != nil {
-			fmt.Println(d_err)
+			fmt.Fprintln(runOutput, d_err)
 		}
 		if isDebug {
-			fmt.Println("Cleaned reports from:", reports_dir_path)
+			fmt.Fprintln(runOutput, "Cleaned reports from:", reports_dir_path)
 		}
 	}
-	err := os.MkdirAll(reports_dir_path, 0777)
+	return os.MkdirAll(reports_dir_path, 0777)
+}
+
+// prepareConfigFlow looks up the model of a config flow, settles the project its test
+// run goes to and picks the provider key sent with it.
+func prepareConfigFlow(okareoAPIKey string, projectId string, flow *FlowConfig, config_keys ModelKeys, isDebug bool) (model *Model, model_keys []ProviderKey, retries int, err error) {
+	model, retries, err = get_model(okareoAPIKey, flow.Name, flow.Model_id, isDebug)
 	if err != nil {
-		fmt.Println("Error creating reports directory.")
-		os.Exit(0)
+		return nil, nil, retries, err
+	}
+	flow_keys := ModelKeys{}
+	if flow.OpenAIKey != "" {
+		flow_keys["openai"] = flow.OpenAIKey
+	}
+	for provider, key := range flow.ModelKeys {
+		flow_keys[provider] = key
+	}
+	model_keys = resolveModelKeys(model, flow_keys, config_keys)
+	if missing := missingModelKeys(model_keys); len(missing) > 0 {
+		fmt.Fprintln(runOutput, "Warning: No "+strings.Join(missing, ", ")+" key for flow '"+flow.Name+"'. Set it under model-keys in config.yml or in the provider's environment variable.")
+	}
+	if isDebug {
+		for _, key := range model_keys {
+			fmt.Fprintln(runOutput, "Debug: Using the "+key.Provider+" key from "+valueOrNone(key.Source)+".")
+		}
+	}
+	project_id := model.ProjectID
+	configured_project_id := projectId
+	if flow_project := tradeForEnvValue(flow.Project_id); flow_project != "" {
+		configured_project_id, err = lookupProjectID(okareoAPIKey, flow_project)
+		if err != nil {
+			return nil, nil, retries, fmt.Errorf("unable to resolve project '%s' for flow '%s': %v", flow_project, flow.Name, err)
+		}
+	}
+	if configured_project_id != "" && configured_project_id != project_id {
+		fmt.Fprintln(runOutput, "Warning: The model for flow '"+flow.Name+"' belongs to project "+project_id+", not the configured project "+configured_project_id+". Using the model's project.")
 	}
+	flow.Project_id = project_id
+	return model, model_keys, retries, nil
+}
+
+// scriptFlow resolves the command, environment and run settings of a flow file.
+func scriptFlow(ctx *RunContext, runner FlowRunner, flow FlowFile, config Config, timeout time.Duration, retries int) (ScriptFlow, error) {
+	args, err := runner.Command(ctx, flow)
+	if err != nil {
+		return ScriptFlow{}, err
+	}
+	flow_output := ctx.OutputFile
+	own_output := false
+	if flow_output == "" && ctx.ReportsDirPath != "" {
+		// flows in sub folders keep their folder in the reports directory
+		flow_output = filepath.Join(ctx.ReportsDirPath, filepath.FromSlash(flow.Name)+".result.json")
+		own_output = true
+	}
+	added_env := append(append(flowEnv(ctx.OkareoAPIKey, ctx.ProjectID, ctx.RunName, flow_output, ctx.ReportsDirPath, ctx.IsDebug), runEnv(ctx, flow)...), runner.Env(ctx, flow)...)
+	return ScriptFlow{
+		FlowFile:   flow,
+		Args:       args,
+		Env:        append(os.Environ(), added_env...),
+		AddedEnv:   added_env,
+		Timeout:    flowTimeout(flow.Name, config.Run.Flows.Timeouts, timeout),
+		Retries:    flowRetries(flow.Name, config.Run.Flows.Retries, retries),
+		OutputFile: flow_output,
+		OwnOutput:  own_output,
+	}, nil
 }
 
-func get_model(api_token string, flow_name string, model_id string, isDebug bool) *Model {
+// get_model looks up the model of a config flow. Errors name the flow, so a bad model
+// fails only the flows that use it.
+func get_model(api_token string, flow_name string, model_id string, isDebug bool) (*Model, int, error) {
 	endpoint := get_endpoint()
 	url := endpoint + "/v0/models_under_test/" + model_id
-	var client http.Client
+	client := newHTTPClient()
 	req, _ := http.NewRequest("GET", url, nil)
 	req.Header.Add("api-key", api_token)
-	resp, err := client.Do(req)
+	resp, retries, err := doWithRetry(client, req)
 	if err != nil {
-		fmt.Println("Error: Please verify your OKAREO_API_KEY is valid and available.")
 		if isDebug {
-			fmt.Println(err)
+			fmt.Fprintln(runOutput, err)
 		}
-		os.Exit(0)
-	}
-
-	model := &Model{}
-	derr := json.NewDecoder(resp.Body).Decode(model)
-	if derr != nil {
-		println("Error decoding model.", derr)
-		panic(derr)
+		return nil, retries, fmt.Errorf("unable to look up the model for flow '%s'. Please verify your OKAREO_API_KEY is valid and available: %v", flow_name, err)
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusCreated {
-		fmt.Println("Error: The model_id for flow '" + flow_name + "' is not valid.")
 		if isDebug {
-			fmt.Println(resp.Status)
+			fmt.Fprintln(runOutput, resp.Status)
 		}
-		os.Exit(0)
+		return nil, retries, fmt.Errorf("the model_id '%s' for flow '%s' is not valid (%s)", model_id, flow_name, resp.Status)
 	}
-	return model
+
+	model := &Model{}
+	if err := json.NewDecoder(resp.Body).Decode(model); err != nil {
+		return nil, retries, fmt.Errorf("unable to decode the model for flow '%s': %v", flow_name, err)
+	}
+	return model, retries, nil
 }
 
-func run_config_test(api_token string, model_type string, model_key string, flow *FlowConfig, reports_dir_path string, isDebug bool) *TestRun {
+func run_config_test(ctx context.Context, api_token string, model_keys []ProviderKey, flow *FlowConfig, tags []string, reports_dir_path string, isDebug bool) (*TestRun, int, error) {
 	endpoint := get_endpoint()
 	url := endpoint + "/v0/test_run"
-	var client http.Client
-	checks := ""
-	if len(flow.Checks) > 0 {
-		checks = `,
-		"checks": [`
-		for i := 0; i < len(flow.Checks); i++ {
-			if i > 0 {
-				checks += `,`
-			}
-			checks += `"` + flow.Checks[i] + `"`
-		}
-		checks += `]`
-	}
-
-	body_str := `{
-		"name":"` + flow.Name + `",
-		"project_id":"` + flow.Project_id + `",
-		"scenario_id":"` + flow.Scenario_id + `",
-		"mut_id":"` + flow.Model_id + `",
-		"type":"` + flow.Type + `",
-		"calculate_metrics": "true",
-		"api_keys": {
-			"` + model_type + `": "` + model_key + `"
-		}` + checks + `
-	}`
-	body := []byte(body_str)
+	client := newHTTPClient()
+	body := test_run_body(model_keys, flow, tags)
 
-	req, _ := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(body))
 
 	req.Header.Add("api-key", api_token)
 	req.Header.Add("Content-Type", "application/json")
 
-	resp, err := client.Do(req)
+	resp, retries, err := doWithRetry(client, req)
 	if err != nil {
-		fmt.Println("Error making request. ", err)
-		fmt.Print(err)
+		return nil, retries, err
 	}
 
+	defer resp.Body.Close()
 	resultBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Println("Error reading body. ", err)
-		log.Fatal(err)
+		return nil, retries, err
+	}
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return nil, retries, &APIError{StatusCode: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(resultBody))}
 	}
 	resultBodyString := string(resultBody)
 	var prettyJSON bytes.Buffer
 	error := json.Indent(&prettyJSON, resultBody, "", "\t")
 	if error != nil {
 		log.Println("JSON parse error: ", error)
-		return nil
+		return nil, retries, error
 	}
 	var prettyJSONString = prettyJSON.String()
 	if isDebug {
@@ -405,14 +632,70 @@ func run_config_test(api_token string, model_type string, model_key string, flow
 	var config_report_file_path string = reports_dir_path + strings.Replace(flow.Name, " ", "_", -1) + ".json"
 	_, err_config_report := os.Stat(reports_dir_path)
 	if os.IsNotExist(err_config_report) {
-		fmt.Println("Report location error: ", err_config_report)
+		fmt.Fprintln(runOutput, "Report location error: ", err_config_report)
 	} else {
 		ftsc_err := os.WriteFile(config_report_file_path, []byte(prettyJSONString), 0777)
 		check(ftsc_err)
 	}
 
 	testrun := jsonTestDecoder(resultBodyString)
-	return testrun
+	var details struct {
+		ModelMetrics map[string]interface{} `json:"model_metrics"`
+	}
+	if json.Unmarshal(resultBody, &details) == nil {
+		testrun.ModelMetrics = details.ModelMetrics
+	}
+	return testrun, retries, nil
+}
+
+// runEnv passes the run metadata and the test run tags to a script flow.
+func runEnv(ctx *RunContext, flow FlowFile) []string {
+	env := ctx.Metadata.Env()
+	if tags := testRunTags(ctx, flow.Tags); len(tags) > 0 {
+		env = append(env, "OKAREO_RUN_TAGS="+strings.Join(tags, ","))
+	}
+	return env
+}
+
+// testRunTags are the tags of a test run: those of its flow, --run-tag and the run metadata.
+func testRunTags(ctx *RunContext, flow_tags []string) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, tag := range append(append(append([]string{}, flow_tags...), ctx.Tags...), ctx.Metadata.Tags()...) {
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// test_run_body is the /v0/test_run payload for a config flow. A matrix cell's
+// parameter set is merged into it.
+func test_run_body(model_keys []ProviderKey, flow *FlowConfig, tags []string) []byte {
+	request := map[string]interface{}{
+		"name":              flow.Name,
+		"project_id":        flow.Project_id,
+		"scenario_id":       flow.Scenario_id,
+		"mut_id":            flow.Model_id,
+		"type":              flow.Type,
+		"calculate_metrics": "true",
+		"api_keys":          apiKeysMap(model_keys),
+	}
+	if len(flow.Checks) > 0 {
+		request["checks"] = flow.Checks
+	}
+	if len(tags) > 0 {
+		request["tags"] = tags
+	}
+	if flow.Cell != nil {
+		for key, value := range flow.Cell.Fields {
+			request[key] = value
+		}
+	}
+	body, err := json.Marshal(request)
+	check(err)
+	return body
 }
 
 func jsonTestDecoder(body string) *TestRun {
@@ -454,7 +737,7 @@ func jsonTestDecoder(body string) *TestRun {
 	return testrun
 }
 
-func installOkareoPython(debug bool) {
+func installOkareoPython(debug bool, reinstall bool, offline bool) error {
 	req_txt := []byte(`# Python requirements to evaluate models with Okareo
 okareo
 `)
@@ -462,121 +745,46 @@ okareo
 	_, err_req := os.Stat(req_file)
 	if os.IsNotExist(err_req) {
 		if debug {
-			fmt.Println("Debug: requirements.txt not found. Creating one.")
+			fmt.Fprintln(runOutput, "Debug: requirements.txt not found. Creating one.")
 		}
-		f_err := os.WriteFile(req_file, req_txt, 0644)
-		check(f_err)
-		if debug {
-			fmt.Println("Requirements file created.")
+		if err := os.WriteFile(req_file, req_txt, 0644); err != nil {
+			return err
 		}
-	} else {
 		if debug {
-			fmt.Println("Requirements file present.")
-		}
-	}
-	/*
-		pip_cmd := exec.Command("python3", "-m", "pip", "install", "--upgrade", "pip")
-		pip_pipe, err := pip_cmd.StdoutPipe()
-		if err != nil {
-			log.Fatal(err)
-		}
-		if err := pip_cmd.Start(); err != nil {
-			log.Fatal(err)
+			fmt.Fprintln(runOutput, "Requirements file created.")
 		}
+	} else {
 		if debug {
-			reader := bufio.NewReader(pip_pipe)
-			line, err := reader.ReadString('\n')
-			for err == nil {
-				fmt.Print(line)
-				line, err = reader.ReadString('\n')
-			}
-		}
-		if err := pip_cmd.Wait(); err != nil {
-			log.Fatal(err)
-		}
-	*/
-
-	req_cmd := exec.Command("python3", "-m", "pip", "install", "-r", req_file)
-	cmd_pipe, err := req_cmd.StdoutPipe()
-	if err != nil {
-		log.Fatal(err)
-	}
-	if err := req_cmd.Start(); err != nil {
-		log.Fatal(err)
-	}
-	if debug {
-		reader := bufio.NewReader(cmd_pipe)
-		line, err := reader.ReadString('\n')
-		for err == nil {
-			fmt.Print(line)
-			line, err = reader.ReadString('\n')
-		}
-	}
-	if err := req_cmd.Wait(); err != nil {
-		log.Fatal(err)
-	}
-}
-
-func doPythonScript(filename string, okareoAPIKey string, projectId string, run_name string, outputFile string, reports_dir_path string, isDebug bool) {
-	cmd := exec.Command("python3", filename)
-
-	// Setup the environment for the caller
-	cmd.Env = os.Environ()
-	if okareoAPIKey != "" {
-		if isDebug {
-			fmt.Println("Debug: Setting OKAREO_API_KEY.")
+			fmt.Fprintln(runOutput, "Requirements file present.")
 		}
-		cmd.Env = append(cmd.Env, "OKAREO_API_KEY="+okareoAPIKey)
 	}
-	if run_name != "" {
-		if isDebug {
-			fmt.Println("Debug: Setting OKAREO_RUN_ID.")
+	req_hash := hashFiles(req_file)
+	if offline {
+		if !fileExists(pythonInterpreter()) {
+			return fmt.Errorf("--offline was set but %s does not exist. Run once without --offline", python_venv_dir)
 		}
-		cmd.Env = append(cmd.Env, "OKAREO_RUN_ID="+run_name)
-	}
-	if projectId != "" {
-		if isDebug {
-			fmt.Println("Debug: Setting PROJECT_ID.")
+		if readMarker(python_venv_marker) != req_hash {
+			fmt.Fprintln(runOutput, "Warning: requirements.txt changed since the last install. Using the existing environment because of --offline.")
 		}
-		cmd.Env = append(cmd.Env, "PROJECT_ID="+projectId)
+		return nil
 	}
-	if outputFile != "" {
-		if isDebug {
-			fmt.Println("Debug: Setting OKAREO_JSON_OUTPUT_FILE.")
-		}
-		cmd.Env = append(cmd.Env, "OKAREO_JSON_OUTPUT_FILE="+outputFile)
+	if err := ensurePythonVenv(debug); err != nil {
+		return err
 	}
-	if reports_dir_path != "" {
-		if isDebug {
-			fmt.Println("Debug: Setting OKAREO_REPORT_DIR.")
+	if !reinstall && readMarker(python_venv_marker) == req_hash {
+		if debug {
+			fmt.Fprintln(runOutput, "Debug: requirements.txt unchanged. Skipping install.")
 		}
-		cmd.Env = append(cmd.Env, "OKAREO_REPORT_DIR="+reports_dir_path)
-	}
-
-	// setup the output handling and call the script
-	pipe, err := cmd.StdoutPipe()
-	if isDebug {
-		cmd.Stderr = os.Stderr
-	}
-	if err != nil {
-		log.Fatal(err)
-	}
-	if err := cmd.Start(); err != nil {
-		log.Fatal(err)
-	}
-	reader := bufio.NewReader(pipe)
-	line, err := reader.ReadString('\n')
-	for err == nil {
-		fmt.Println(line)
-		line, err = reader.ReadString('\n')
+		return nil
 	}
 
-	if err := cmd.Wait(); err != nil {
-		log.Fatal(err)
+	if err := runSetupCommand(pythonInstallCommand(req_file), debug); err != nil {
+		return err
 	}
+	return os.WriteFile(python_venv_marker, []byte(req_hash+"\n"), 0644)
 }
 
-func installOkareoTypescript(debug bool) {
+func installOkareoTypescript(debug bool, reinstall bool, offline bool) error {
 	// create the tsconfig file and overwrite if it already exists
 	tsconfig_json := []byte(`
 	{
@@ -587,16 +795,19 @@ func installOkareoTypescript(debug bool) {
 		  "target": "es6",
 		  "moduleResolution": "node",
 		  "sourceMap": true,
+		  "rootDir": "flows",
 		  "outDir": "dist"
 		},
+		"include": ["flows"],
 		"lib": ["es2015"]
 	}  
 	`)
 	var tsconfig_file string = "./.okareo/tsconfig.json"
 	_, err := os.Stat(tsconfig_file)
 	if os.IsNotExist(err) {
-		ftsc_err := os.WriteFile(tsconfig_file, tsconfig_json, 0777)
-		check(ftsc_err)
+		if err := os.WriteFile(tsconfig_file, tsconfig_json, 0777); err != nil {
+			return err
+		}
 	}
 
 	// create the package.json file and overwrite if it already exists
@@ -621,36 +832,22 @@ func installOkareoTypescript(debug bool) {
 	var package_file string = "./.okareo/package.json"
 	_, err_pkg := os.Stat(package_file)
 	if os.IsNotExist(err_pkg) {
-		f_err := os.WriteFile(package_file, package_json, 0777)
-		check(f_err)
-	}
-
-	cmd := exec.Command("npm", "install")
-	cmd.Dir = "./.okareo"
-	pipe, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Fatal(err)
-	}
-	if err := cmd.Start(); err != nil {
-		log.Fatal(err)
-	}
-	if debug {
-		reader := bufio.NewReader(pipe)
-		line, err := reader.ReadString('\n')
-		for err == nil {
-			fmt.Print(line)
-			line, err = reader.ReadString('\n')
+		if err := os.WriteFile(package_file, package_json, 0777); err != nil {
+			return err
 		}
 	}
-	if err := cmd.Wait(); err != nil {
-		log.Fatal(err)
-	}
 
+	return npmInstall(debug, reinstall, offline)
 }
 
-func doTSBuild(isDebug bool) {
+// doTSBuild compiles the typescript flows. Incremental builds (used by --watch) only
+// recompile what changed since the last build.
+func doTSBuild(isDebug bool, incremental bool) error {
 	println("Building typescript flows")
 	cmd := exec.Command("npm", "run", "build")
+	if incremental {
+		cmd = exec.Command("npm", "run", "build", "--", "--incremental")
+	}
 	cmd.Dir = "./.okareo"
 	// Setup the environment for the caller
 	cmd.Env = os.Environ()
@@ -663,24 +860,22 @@ func doTSBuild(isDebug bool) {
 	cmd.Stderr = os.Stderr
 
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	if err := cmd.Start(); err != nil {
-		log.Fatal(err)
+		return err
 	}
 	reader := bufio.NewReader(pipe)
 	line, err := reader.ReadString('\n')
 	for err == nil {
-		fmt.Print(line)
+		fmt.Fprint(runOutput, line)
 		line, err = reader.ReadString('\n')
 	}
 
-	if err := cmd.Wait(); err != nil {
-		log.Fatal(err)
-	}
+	return cmd.Wait()
 }
 
-func installOkareoJavascript(debug bool) {
+func installOkareoJavascript(debug bool, reinstall bool, offline bool) error {
 	// create the package.json file and overwrite if it already exists
 	package_json := []byte(`
 	{
@@ -697,76 +892,81 @@ func installOkareoJavascript(debug bool) {
 	var package_file string = "./.okareo/package.json"
 	_, err_pkg := os.Stat(package_file)
 	if os.IsNotExist(err_pkg) {
-		f_err := os.WriteFile(package_file, package_json, 0777)
-		check(f_err)
+		if err := os.WriteFile(package_file, package_json, 0777); err != nil {
+			return err
+		}
 	}
 
-	cmd := exec.Command("npm", "install")
-	cmd.Dir = "./.okareo"
-	pipe, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Fatal(err)
-	}
-	if err := cmd.Start(); err != nil {
-		log.Fatal(err)
+	return npmInstall(debug, reinstall, offline)
+}
+
+// npmInstall runs npm install in .okareo unless package.json and package-lock.json
+// are unchanged since the last install.
+func npmInstall(debug bool, reinstall bool, offline bool) error {
+	npm_hash := hashFiles(npm_dependency_files...)
+	if offline {
+		if !dirExists(node_modules_dir) {
+			return fmt.Errorf("--offline was set but %s does not exist. Run once without --offline", node_modules_dir)
+		}
+		if readMarker(node_modules_marker) != npm_hash {
+			fmt.Fprintln(runOutput, "Warning: package.json changed since the last install. Using the existing node_modules because of --offline.")
+		}
+		return nil
 	}
-	if debug {
-		reader := bufio.NewReader(pipe)
-		line, err := reader.ReadString('\n')
-		for err == nil {
-			fmt.Print(line)
-			line, err = reader.ReadString('\n')
+	if !reinstall && dirExists(node_modules_dir) && readMarker(node_modules_marker) == npm_hash {
+		if debug {
+			fmt.Fprintln(runOutput, "Debug: package.json unchanged. Skipping npm install.")
 		}
+		return nil
+	}
+
+	cmd := exec.Command("npm", "install")
+	cmd.Dir = "./.okareo"
+	if err := runSetupCommand(cmd, debug); err != nil {
+		return err
 	}
-	if err := cmd.Wait(); err != nil {
-		log.Fatal(err)
+	// npm may rewrite package-lock.json, so hash again after installing
+	if err := os.MkdirAll(node_modules_dir, 0777); err != nil {
+		return err
 	}
+	return os.WriteFile(node_modules_marker, []byte(hashFiles(npm_dependency_files...)+"\n"), 0644)
 }
 
-func doJSScript(filename string, okareoAPIKey string, projectId string, run_name string, outputFile string, reports_dir_path string, isDebug bool) {
-	cmd := exec.Command("node", filename)
-
-	// Setup the environment for the caller
-	cmd.Env = os.Environ()
-	if run_name != "" {
-		cmd.Env = append(cmd.Env, "OKAREO_RUN_ID="+run_name)
-	}
+// flowEnv builds the environment contract shared by every flow script. It returns only
+// the variables okareo sets; flows get them on top of the CLI's own environment.
+func flowEnv(okareoAPIKey string, projectId string, run_name string, outputFile string, reports_dir_path string, isDebug bool) []string {
+	env := endpointEnv()
 	if okareoAPIKey != "" {
-		cmd.Env = append(cmd.Env, "OKAREO_API_KEY="+okareoAPIKey)
+		if isDebug {
+			fmt.Fprintln(runOutput, "Debug: Setting OKAREO_API_KEY.")
+		}
+		env = append(env, "OKAREO_API_KEY="+okareoAPIKey)
+	}
+	if run_name != "" {
+		if isDebug {
+			fmt.Fprintln(runOutput, "Debug: Setting OKAREO_RUN_ID.")
+		}
+		env = append(env, "OKAREO_RUN_ID="+run_name)
 	}
 	if projectId != "" {
-		cmd.Env = append(cmd.Env, "PROJECT_ID="+projectId)
+		if isDebug {
+			fmt.Fprintln(runOutput, "Debug: Setting PROJECT_ID.")
+		}
+		env = append(env, "PROJECT_ID="+projectId)
 	}
 	if outputFile != "" {
-		cmd.Env = append(cmd.Env, "OKAREO_JSON_OUTPUT_FILE="+outputFile)
+		if isDebug {
+			fmt.Fprintln(runOutput, "Debug: Setting OKAREO_JSON_OUTPUT_FILE.")
+		}
+		env = append(env, "OKAREO_JSON_OUTPUT_FILE="+outputFile)
 	}
 	if reports_dir_path != "" {
-		cmd.Env = append(cmd.Env, "OKAREO_REPORT_DIR="+reports_dir_path)
-	}
-
-	// setup the output handling and call the script
-	pipe, err := cmd.StdoutPipe()
-	cmd.Stderr = os.Stderr
-
-	if err != nil {
-		log.Fatal(err)
-	}
-	if err := cmd.Start(); err != nil {
-		log.Fatal(err)
-	}
-	reader := bufio.NewReader(pipe)
-	line, err := reader.ReadString('\n')
-	for err == nil {
-		fmt.Print(line)
-		line, err = reader.ReadString('\n')
-	}
-
-	if err := cmd.Wait(); err != nil {
 		if isDebug {
-			fmt.Println("Error: ", err)
+			fmt.Fprintln(runOutput, "Debug: Setting OKAREO_REPORT_DIR.")
 		}
-		log.Fatal(err)
+		env = append(env, "OKAREO_REPORT_DIR="+reports_dir_path)
 	}
+	return env
 }
 
 func tradeForEnvValue(envVar string) string {
@@ -778,9 +978,25 @@ func tradeForEnvValue(envVar string) string {
 
 func init() {
 	rootCmd.AddCommand(runCmd)
-	runCmd.PersistentFlags().StringP("file", "f", "ALL", "The Okareo flow script you want to run.")
+	runCmd.PersistentFlags().StringSliceP("flow", "f", nil, "Only run flows matching this glob, e.g. 'retrieval' or 'eval_*.py'. Repeat for several globs.")
+	runCmd.PersistentFlags().StringSlice("file", nil, "Same as --flow.")
+	runCmd.PersistentFlags().MarkHidden("file")
+	runCmd.PersistentFlags().StringSlice("exclude", nil, "Skip flows matching this glob. Repeat for several globs.")
+	runCmd.PersistentFlags().StringSliceP("tag", "t", nil, "Only run flows with this tag, from 'tags' in config.yml or an 'okareo-tags:' comment in a script. Repeat to require several tags.")
+	runCmd.PersistentFlags().Bool("list", false, "List the flows that would run, without running them.")
+	runCmd.PersistentFlags().BoolP("watch", "w", false, "Re-run affected flows when files in .okareo/flows or the config change.")
+	runCmd.PersistentFlags().Bool("dry-run", false, "Resolve the config, models and scenarios and show each flow's command, environment and test run payload without installing or running anything.")
 	runCmd.PersistentFlags().StringP("config", "c", "./.okareo/config.yml", "The Okareo configuration file for the evaluation run.")
+	runCmd.PersistentFlags().Bool("all-workspaces", false, "Run every .okareo workspace under the git repository (or --workspace) and print a combined summary.")
 	runCmd.PersistentFlags().StringP("reports", "r", "reports", "The folder where eval results are made available. Defaults to ./.okareo/reports/")
-	runCmd.PersistentFlags().StringP("outputFile", "o", "", "The eval reports folder where local json results are made available.")
+	runCmd.PersistentFlags().StringP("outputFile", "o", "", "The result file of the script flow (OKAREO_JSON_OUTPUT_FILE), for runs of a single script flow. Defaults to <reports>/<flow>.result.json. To change the reports folder, use --reports.")
 	runCmd.PersistentFlags().BoolP("debug", "d", false, "See additional stdout to debug your flows.")
+	runCmd.PersistentFlags().Bool("reinstall", false, "Reinstall flow dependencies even if requirements.txt and package.json are unchanged.")
+	runCmd.PersistentFlags().StringSlice("run-tag", nil, "Add this tag to every test run the run creates. Repeat for several tags. (--tag selects flows.)")
+	runCmd.PersistentFlags().String("run-name", "", "A template for the run name, e.g. '{{.Name}}-{{.GitShortSHA}}-{{.Date}}'. Defaults to 'run-name' in config.yml or '{{.Name}}-{{.Random}}'.")
+	runCmd.PersistentFlags().Duration("timeout", 0, "Stop any flow that runs longer than this, e.g. 10m. Timeouts set per flow in config.yml take precedence.")
+	runCmd.PersistentFlags().Int("retries", 0, "Retry script flows that exit non-zero up to this many times. Retries set per flow in config.yml take precedence.")
+	runCmd.PersistentFlags().Int("api-retries", 3, "Retry model lookups that get a 429 or 5xx response, and test runs that get a 429 or a 503 with Retry-After, up to this many times with exponential backoff.")
+	runCmd.PersistentFlags().String("output", "text", "The run output: text, or json or ndjson to print structured events on stdout. Other output goes to stderr. --list and --dry-run print text only, and --watch needs ndjson.")
+	runCmd.PersistentFlags().Bool("offline", false, "Never install flow dependencies. Uses the existing .okareo/.venv or node_modules.")
 }
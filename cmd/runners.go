@@ -0,0 +1,368 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"text/template"
+)
+
+// RunContext carries the settings of one `okareo run` to the flow runners.
+type RunContext struct {
+	OkareoAPIKey   string
+	ProjectID      string
+	RunName        string
+	OutputFile     string
+	ReportsDirPath string
+	Reinstall      bool
+	Offline        bool
+	Incremental    bool     // rebuild only what changed, for --watch
+	Tags           []string // --run-tag, added to every test run
+	Metadata       RunMetadata
+	IsDebug        bool
+}
+
+// FlowRunner knows how to prepare and execute the script flows of one language.
+// Install and Build run once per `okareo run` for the runners that have matching flows.
+type FlowRunner interface {
+	Name() string
+	// Detect reports whether a file in .okareo/flows belongs to this runner
+	Detect(filename string) bool
+	Install(ctx *RunContext) error
+	Build(ctx *RunContext, flows []FlowFile) error
+	// Command returns the program and arguments that run a flow
+	Command(ctx *RunContext, flow FlowFile) ([]string, error)
+	// Env returns variables added to the shared flow environment (see flowEnv)
+	Env(ctx *RunContext, flow FlowFile) []string
+}
+
+// RunnerConfig declares a custom runner in config.yml under run.flows.runners.
+type RunnerConfig struct {
+	Name        string            `yaml:"name"`
+	FilePattern string            `yaml:"file-pattern"`
+	Install     string            `yaml:"install"`
+	Build       string            `yaml:"build"`
+	Command     string            `yaml:"command"`
+	Env         map[string]string `yaml:"env"`
+}
+
+// flowRunners returns the custom runners from config followed by the built-in ones.
+// Custom runners are detected first so they can claim extensions such as .js or .ts.
+func flowRunners(configs []RunnerConfig) ([]FlowRunner, error) {
+	runners := []FlowRunner{}
+	for _, config := range configs {
+		runner, err := newCustomRunner(config)
+		if err != nil {
+			return nil, err
+		}
+		runners = append(runners, runner)
+	}
+	// typescript installs before javascript so the shared package.json includes the compiler
+	return append(runners, &pythonRunner{}, &typescriptRunner{}, &javascriptRunner{}, &goRunner{}), nil
+}
+
+func findRunner(runners []FlowRunner, name string) FlowRunner {
+	for _, runner := range runners {
+		if runner.Name() == name {
+			return runner
+		}
+	}
+	return nil
+}
+
+func hasExtension(filename string, extensions ...string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, e := range extensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+type pythonRunner struct{}
+
+func (r *pythonRunner) Name() string                { return "python" }
+func (r *pythonRunner) Detect(filename string) bool { return hasExtension(filename, ".py") }
+
+func (r *pythonRunner) Install(ctx *RunContext) error {
+	// requirements are reinstalled into .okareo/.venv only when requirements.txt changes
+	return installOkareoPython(ctx.IsDebug, ctx.Reinstall, ctx.Offline)
+}
+
+func (r *pythonRunner) Build(ctx *RunContext, flows []FlowFile) error { return nil }
+
+func (r *pythonRunner) Command(ctx *RunContext, flow FlowFile) ([]string, error) {
+	return []string{pythonInterpreter(), flow.Path}, nil
+}
+
+func (r *pythonRunner) Env(ctx *RunContext, flow FlowFile) []string { return nil }
+
+type typescriptRunner struct{}
+
+func (r *typescriptRunner) Name() string                { return "typescript" }
+func (r *typescriptRunner) Detect(filename string) bool { return hasExtension(filename, ".ts") }
+
+func (r *typescriptRunner) Install(ctx *RunContext) error {
+	return installOkareoTypescript(ctx.IsDebug, ctx.Reinstall, ctx.Offline)
+}
+
+func (r *typescriptRunner) Build(ctx *RunContext, flows []FlowFile) error {
+	return doTSBuild(ctx.IsDebug, ctx.Incremental)
+}
+
+func (r *typescriptRunner) Command(ctx *RunContext, flow FlowFile) ([]string, error) {
+	return []string{"node", typescriptDistPath("./.okareo", flow)}, nil
+}
+
+// TSConfig holds the parts of .okareo/tsconfig.json that decide where tsc writes a flow.
+type TSConfig struct {
+	CompilerOptions struct {
+		RootDir string `json:"rootDir"`
+		OutDir  string `json:"outDir"`
+	} `json:"compilerOptions"`
+	Include []string `json:"include"`
+}
+
+// readTSConfig reads the tsconfig.json in dir. A missing or unreadable file, such as
+// one with comments, is taken to be the one `okareo run` generates.
+func readTSConfig(dir string) TSConfig {
+	config := TSConfig{}
+	data, err := os.ReadFile(filepath.Join(dir, "tsconfig.json"))
+	if err != nil || json.Unmarshal(data, &config) != nil {
+		config = TSConfig{Include: []string{"flows"}}
+		config.CompilerOptions.RootDir = "flows"
+		config.CompilerOptions.OutDir = "dist"
+	}
+	return config
+}
+
+// typescriptDistPath is where tsc writes a flow, following the rootDir and outDir of
+// the tsconfig.json in okareo_dir. Older generated tsconfigs have no rootDir, so tsc
+// mirrors the folders under the common root of its sources instead.
+func typescriptDistPath(okareo_dir string, flow FlowFile) string {
+	config := readTSConfig(okareo_dir)
+	source := path.Join("flows", flow.Name)
+	js_file := strings.TrimSuffix(source, path.Ext(source)) + ".js"
+	out_dir := filepath.ToSlash(config.CompilerOptions.OutDir)
+	if out_dir == "" {
+		// without outDir tsc writes next to the source
+		return path.Join(filepath.ToSlash(okareo_dir), js_file)
+	}
+	root := filepath.ToSlash(config.CompilerOptions.RootDir)
+	if root == "" {
+		root = typescriptSourceRoot(okareo_dir, config)
+	}
+	rel, err := filepath.Rel(filepath.FromSlash(path.Clean(root)), filepath.FromSlash(js_file))
+	if err != nil {
+		rel = filepath.FromSlash(js_file)
+	}
+	return path.Join(filepath.ToSlash(okareo_dir), out_dir, filepath.ToSlash(rel))
+}
+
+// typescriptSourceRoot is the folder tsc picks as the root of its output when rootDir
+// isn't set: the common folder of the .ts files it compiles, relative to okareo_dir.
+func typescriptSourceRoot(okareo_dir string, config TSConfig) string {
+	out_dir := path.Clean(filepath.ToSlash(config.CompilerOptions.OutDir))
+	includes := []string{}
+	for _, include := range config.Include {
+		// walk the part of the pattern before its first wildcard
+		prefix := []string{}
+		for _, part := range strings.Split(path.Clean(filepath.ToSlash(include)), "/") {
+			if strings.ContainsAny(part, "*?[") {
+				break
+			}
+			prefix = append(prefix, part)
+		}
+		includes = append(includes, path.Join(prefix...))
+	}
+	if len(config.Include) == 0 {
+		includes = []string{"."}
+	}
+
+	var root []string
+	for _, include := range includes {
+		filepath.WalkDir(filepath.Join(okareo_dir, include), func(file string, e fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			rel, _ := filepath.Rel(okareo_dir, file)
+			rel = filepath.ToSlash(rel)
+			if e.IsDir() {
+				if rel != "." && (rel == out_dir || e.Name() == "node_modules" || strings.HasPrefix(e.Name(), ".")) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(rel, ".d.ts") || !hasExtension(rel, ".ts", ".tsx") {
+				return nil
+			}
+			dir := strings.Split(path.Dir(rel), "/")
+			if root == nil {
+				root = dir
+				return nil
+			}
+			common := 0
+			for common < len(root) && common < len(dir) && root[common] == dir[common] {
+				common++
+			}
+			root = root[:common]
+			return nil
+		})
+	}
+	if root == nil {
+		return "flows"
+	}
+	if len(root) == 0 {
+		return "."
+	}
+	return path.Join(root...)
+}
+
+func (r *typescriptRunner) Env(ctx *RunContext, flow FlowFile) []string { return nil }
+
+type javascriptRunner struct{}
+
+func (r *javascriptRunner) Name() string { return "javascript" }
+func (r *javascriptRunner) Detect(filename string) bool {
+	return hasExtension(filename, ".js", ".mjs", ".cjs")
+}
+
+func (r *javascriptRunner) Install(ctx *RunContext) error {
+	return installOkareoJavascript(ctx.IsDebug, ctx.Reinstall, ctx.Offline)
+}
+
+func (r *javascriptRunner) Build(ctx *RunContext, flows []FlowFile) error { return nil }
+
+func (r *javascriptRunner) Command(ctx *RunContext, flow FlowFile) ([]string, error) {
+	return []string{"node", flow.Path}, nil
+}
+
+func (r *javascriptRunner) Env(ctx *RunContext, flow FlowFile) []string { return nil }
+
+type goRunner struct{}
+
+func (r *goRunner) Name() string                { return "go" }
+func (r *goRunner) Detect(filename string) bool { return hasExtension(filename, ".go") }
+
+func (r *goRunner) Install(ctx *RunContext) error {
+	return installOkareoGo(ctx.IsDebug, ctx.Reinstall, ctx.Offline)
+}
+
+func (r *goRunner) Build(ctx *RunContext, flows []FlowFile) error {
+	for _, flow := range flows {
+		if _, err := doGoBuild(flow.Path, ctx.Offline, ctx.IsDebug); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *goRunner) Command(ctx *RunContext, flow FlowFile) ([]string, error) {
+	return []string{goBinaryPath(flow.Path)}, nil
+}
+
+func (r *goRunner) Env(ctx *RunContext, flow FlowFile) []string { return nil }
+
+// customRunner runs flows with shell command templates declared in config.yml.
+type customRunner struct {
+	config  RunnerConfig
+	pattern *regexp.Regexp
+}
+
+// FlowTemplateData is available to custom runner command templates, e.g. "deno run -A {{.File}}".
+type FlowTemplateData struct {
+	File string // path of the flow, relative to the working directory
+	Name string // file name of the flow
+	Base string // file name without its extension
+	Dir  string // the .okareo directory
+}
+
+func newCustomRunner(config RunnerConfig) (*customRunner, error) {
+	if config.Name == "" || config.FilePattern == "" || config.Command == "" {
+		return nil, fmt.Errorf("custom runners need a name, file-pattern and command")
+	}
+	pattern, err := regexp.Compile(config.FilePattern + "$")
+	if err != nil {
+		return nil, fmt.Errorf("runner '%s' has an invalid file-pattern: %v", config.Name, err)
+	}
+	return &customRunner{config: config, pattern: pattern}, nil
+}
+
+func (r *customRunner) Name() string                { return strings.ToLower(r.config.Name) }
+func (r *customRunner) Detect(filename string) bool { return r.pattern.MatchString(filename) }
+
+func (r *customRunner) Install(ctx *RunContext) error {
+	if r.config.Install == "" || ctx.Offline {
+		return nil
+	}
+	cmd := shellCommand(r.config.Install)
+	cmd.Dir = "./.okareo"
+	return runSetupCommand(cmd, ctx.IsDebug)
+}
+
+func (r *customRunner) Build(ctx *RunContext, flows []FlowFile) error {
+	if r.config.Build == "" {
+		return nil
+	}
+	cmd := shellCommand(r.config.Build)
+	cmd.Dir = "./.okareo"
+	return runSetupCommand(cmd, ctx.IsDebug)
+}
+
+func (r *customRunner) Command(ctx *RunContext, flow FlowFile) ([]string, error) {
+	tmpl, err := template.New(r.config.Name).Parse(r.config.Command)
+	if err != nil {
+		return nil, fmt.Errorf("runner '%s' has an invalid command template: %v", r.config.Name, err)
+	}
+	data := FlowTemplateData{
+		File: shellQuote(flow.Path),
+		Name: shellQuote(flow.Name),
+		Base: shellQuote(strings.TrimSuffix(flow.Name, filepath.Ext(flow.Name))),
+		Dir:  shellQuote("./.okareo"),
+	}
+	var command bytes.Buffer
+	if err := tmpl.Execute(&command, data); err != nil {
+		return nil, err
+	}
+	return shellArgs(command.String()), nil
+}
+
+func (r *customRunner) Env(ctx *RunContext, flow FlowFile) []string {
+	env := []string{}
+	for key, value := range r.config.Env {
+		env = append(env, key+"="+tradeForEnvValue(value))
+	}
+	return env
+}
+
+func shellArgs(command string) []string {
+	if runtime.GOOS == "windows" {
+		return []string{"cmd", "/C", command}
+	}
+	return []string{"sh", "-c", command}
+}
+
+func shellCommand(command string) *exec.Cmd {
+	args := shellArgs(command)
+	return exec.Command(args[0], args[1:]...)
+}
+
+// shellQuote quotes template values for sh so flow names with spaces stay one argument.
+func shellQuote(value string) string {
+	if runtime.GOOS == "windows" {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,101 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("cmd /C takes template values as they are")
+	}
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"flow.py", "'flow.py'"},
+		{"", "''"},
+		{"my flow.py", "'my flow.py'"},
+		{"it's.py", `'it'\''s.py'`},
+		{"$(rm -rf x);`id`", "'$(rm -rf x);`id`'"},
+		{`back\slash "quoted"`, `'back\slash "quoted"'`},
+	}
+	for _, tt := range tests {
+		if got := shellQuote(tt.value); got != tt.want {
+			t.Errorf("shellQuote(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+		// the shell must hand the flow exactly the original value
+		out, err := exec.Command("sh", "-c", "printf %s "+shellQuote(tt.value)).Output()
+		if err != nil {
+			t.Fatalf("sh -c with %q: %v", tt.value, err)
+		}
+		if string(out) != tt.value {
+			t.Errorf("sh received %q, want %q", out, tt.value)
+		}
+	}
+}
+
+func TestTypescriptDistPath(t *testing.T) {
+	// the tsconfig.json older versions of okareo generated, without rootDir or include
+	old_tsconfig := `
+	{
+		"compilerOptions": {
+		  "module": "commonjs",
+		  "resolveJsonModule": true,
+		  "esModuleInterop": true,
+		  "target": "es6",
+		  "moduleResolution": "node",
+		  "sourceMap": true,
+		  "outDir": "dist"
+		},
+		"lib": ["es2015"]
+	}  
+	`
+	tests := []struct {
+		name     string
+		tsconfig string // "" leaves tsconfig.json out
+		files    []string
+		flow     string
+		want     string
+	}{
+		{"generated tsconfig", `{"compilerOptions": {"rootDir": "flows", "outDir": "dist"}, "include": ["flows"]}`, []string{"flows/retrieval/search.ts", "flows/gen.ts"}, "retrieval/search.ts", "dist/retrieval/search.js"},
+		{"no tsconfig yet", "", []string{"flows/retrieval/search.ts"}, "retrieval/search.ts", "dist/retrieval/search.js"},
+		{"old tsconfig", old_tsconfig, []string{"flows/retrieval/search.ts", "flows/gen.ts"}, "retrieval/search.ts", "dist/retrieval/search.js"},
+		{"old tsconfig, one nested flow", old_tsconfig, []string{"flows/retrieval/search.ts"}, "retrieval/search.ts", "dist/search.js"},
+		{"old tsconfig, sources outside flows", old_tsconfig, []string{"flows/retrieval/search.ts", "lib/util.ts"}, "retrieval/search.ts", "dist/flows/retrieval/search.js"},
+		{"old tsconfig ignores dist, node_modules and declarations", old_tsconfig, []string{"flows/a/x.ts", "flows/b/y.ts", "dist/old.ts", "node_modules/pkg/index.ts", "types.d.ts"}, "a/x.ts", "dist/a/x.js"},
+		{"dotted name", old_tsconfig, []string{"flows/rerank.v2.ts", "flows/gen.ts"}, "rerank.v2.ts", "dist/rerank.v2.js"},
+		{"custom outDir and rootDir", `{"compilerOptions": {"rootDir": ".", "outDir": "build"}}`, []string{"flows/gen.ts"}, "gen.ts", "build/flows/gen.js"},
+		{"include without rootDir", `{"compilerOptions": {"outDir": "dist"}, "include": ["flows/**/*"]}`, []string{"flows/a/x.ts", "flows/b/y.ts", "lib/util.ts"}, "a/x.ts", "dist/a/x.js"},
+		{"no outDir", `{"compilerOptions": {}}`, []string{"flows/a/x.ts"}, "a/x.ts", "flows/a/x.js"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.tsconfig != "" {
+				if err := os.WriteFile(filepath.Join(dir, "tsconfig.json"), []byte(tt.tsconfig), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, file := range tt.files {
+				file = filepath.Join(dir, filepath.FromSlash(file))
+				if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(file, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got := typescriptDistPath(dir, FlowFile{Name: tt.flow})
+			if want := filepath.ToSlash(filepath.Join(dir, tt.want)); got != want {
+				t.Errorf("typescriptDistPath() = %s, want %s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,474 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+type SeedData struct {
+	Input  interface{} `json:"input"`
+	Result interface{} `json:"result"`
+}
+
+type ScenarioSet struct {
+	ScenarioID    string      `json:"scenario_id"`
+	ProjectID     string      `json:"project_id"`
+	Name          string      `json:"name"`
+	Type          string      `json:"type"`
+	Tags          []string    `json:"tags"`
+	TimeCreated   string      `json:"time_created"`
+	SeedData      []SeedData  `json:"seed_data"`
+	ScenarioCount int         `json:"scenario_count"`
+	ScenarioInput interface{} `json:"scenario_input"`
+	AppLink       string      `json:"app_link"`
+	Warning       string      `json:"warning"`
+}
+
+type ScenarioDataPoint struct {
+	ID       string      `json:"id"`
+	Input    interface{} `json:"input"`
+	Result   interface{} `json:"result"`
+	MetaData interface{} `json:"meta_data,omitempty"`
+}
+
+var scenariosCmd = &cobra.Command{
+	Use:   "scenarios",
+	Short: "Manage Okareo scenario sets",
+	Long: `List, create, upload, generate and download the scenario sets referenced by 'scenario-id' in config.yml.
+Seed data files may be JSONL (one {"input": ..., "result": ...} object per line), a JSON array of the same, or CSV with 'input' and 'result' columns.`,
+}
+
+var scenariosListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the scenario sets in a project",
+	Run: func(cmd *cobra.Command, args []string) {
+		isDebug, _ := cmd.Flags().GetBool("debug")
+		projectFlag, _ := cmd.Flags().GetString("project-id")
+		nameFilter, _ := cmd.Flags().GetString("name")
+		tagFilter, _ := cmd.Flags().GetStringSlice("tag")
+
+		apiKey := requireAPIKey(cmd)
+		projectId := resolveProjectID(apiKey, projectFlag)
+
+		scenarios := []ScenarioSet{}
+		err := doOkareoRequest(apiKey, http.MethodGet, "/v0/scenario_sets?project_id="+url.QueryEscape(projectId), nil, &scenarios)
+		if err != nil {
+			printAPIError("list scenario sets", err, isDebug)
+		}
+
+		filtered := []ScenarioSet{}
+		for _, scenario := range scenarios {
+			if nameFilter != "" && !strings.Contains(strings.ToLower(scenario.Name), strings.ToLower(nameFilter)) {
+				continue
+			}
+			if !hasAllTags(scenario.Tags, tagFilter) {
+				continue
+			}
+			filtered = append(filtered, scenario)
+		}
+
+		printOutput(cmd, filtered, func(w io.Writer) {
+			printRow(w, "ID", "NAME", "TYPE", "ROWS", "TAGS", "CREATED")
+			for _, scenario := range filtered {
+				printRow(w, scenario.ScenarioID, scenario.Name, scenario.Type, fmt.Sprint(scenario.ScenarioCount), strings.Join(scenario.Tags, ","), scenario.TimeCreated)
+			}
+		})
+	},
+}
+
+var scenariosGetCmd = &cobra.Command{
+	Use:   "get <scenario-id>",
+	Short: "Show a scenario set and its data points",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		isDebug, _ := cmd.Flags().GetBool("debug")
+		apiKey := requireAPIKey(cmd)
+
+		scenario := get_scenario_set(apiKey, args[0], isDebug)
+		rows := get_scenario_data_points(apiKey, args[0], isDebug)
+		printOutput(cmd, map[string]interface{}{"scenario_set": scenario, "data_points": rows}, func(w io.Writer) {
+			printRow(w, "ID:", scenario.ScenarioID)
+			printRow(w, "Name:", scenario.Name)
+			printRow(w, "Project:", scenario.ProjectID)
+			printRow(w, "Type:", scenario.Type)
+			printRow(w, "Tags:", strings.Join(scenario.Tags, ","))
+			printRow(w, "Created:", scenario.TimeCreated)
+			printRow(w, "Link:", scenario.AppLink)
+			printRow(w, "")
+			printRow(w, "INPUT", "RESULT")
+			for _, row := range rows {
+				printRow(w, truncate(toText(row.Input), 60), truncate(toText(row.Result), 60))
+			}
+		})
+	},
+}
+
+var scenariosCreateCmd = &cobra.Command{
+	Use:   "create <seed-file>",
+	Short: "Create a scenario set from a local seed data file",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		isDebug, _ := cmd.Flags().GetBool("debug")
+		projectFlag, _ := cmd.Flags().GetString("project-id")
+		name, _ := cmd.Flags().GetString("name")
+		tags, _ := cmd.Flags().GetStringSlice("tag")
+
+		apiKey := requireAPIKey(cmd)
+		projectId := resolveProjectID(apiKey, projectFlag)
+		seeds, err := readSeedData(args[0])
+		if err != nil {
+			fmt.Println("Error: Unable to read seed data from '"+args[0]+"'.", err)
+			os.Exit(1)
+		}
+		if name == "" {
+			name = seedFileName(args[0])
+		}
+
+		body := map[string]interface{}{
+			"name":            name,
+			"project_id":      projectId,
+			"seed_data":       seeds,
+			"tags":            tags,
+			"number_examples": 1,
+			"generation_type": "SEED",
+		}
+		scenario := &ScenarioSet{}
+		if err := doOkareoRequest(apiKey, http.MethodPost, "/v0/scenario_sets", body, scenario); err != nil {
+			printAPIError("create scenario set '"+name+"'", err, isDebug)
+		}
+		printScenarioSummary(cmd, scenario)
+	},
+}
+
+var scenariosUploadCmd = &cobra.Command{
+	Use:   "upload <file>",
+	Short: "Upload a JSONL or CSV file as a scenario set",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		isDebug, _ := cmd.Flags().GetBool("debug")
+		projectFlag, _ := cmd.Flags().GetString("project-id")
+		name, _ := cmd.Flags().GetString("name")
+
+		apiKey := requireAPIKey(cmd)
+		projectId := resolveProjectID(apiKey, projectFlag)
+		if name == "" {
+			name = seedFileName(args[0])
+		}
+
+		// the upload endpoint parses JSONL, so CSV and JSON arrays are converted first
+		seeds, err := readSeedData(args[0])
+		if err != nil {
+			fmt.Println("Error: Unable to read seed data from '"+args[0]+"'.", err)
+			os.Exit(1)
+		}
+		content, err := encodeJSONL(seeds)
+		check(err)
+
+		fields := map[string]string{"name": name, "project_id": projectId}
+		scenario := &ScenarioSet{}
+		err = doOkareoUpload(apiKey, "/v0/scenario_sets_upload", fields, "file", seedFileName(args[0])+".jsonl", content, scenario)
+		if err != nil {
+			printAPIError("upload scenario set '"+name+"'", err, isDebug)
+		}
+		printScenarioSummary(cmd, scenario)
+	},
+}
+
+var scenariosGenerateCmd = &cobra.Command{
+	Use:   "generate <source-scenario-id>",
+	Short: "Generate a new scenario set from an existing one",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		isDebug, _ := cmd.Flags().GetBool("debug")
+		projectFlag, _ := cmd.Flags().GetString("project-id")
+		name, _ := cmd.Flags().GetString("name")
+		count, _ := cmd.Flags().GetInt("count")
+		generation_type, _ := cmd.Flags().GetString("type")
+		tone, _ := cmd.Flags().GetString("tone")
+		lang, _ := cmd.Flags().GetString("lang")
+
+		if name == "" {
+			fmt.Println("Error: --name is required.")
+			os.Exit(1)
+		}
+		apiKey := requireAPIKey(cmd)
+		projectId := resolveProjectID(apiKey, projectFlag)
+
+		body := map[string]interface{}{
+			"source_scenario_id": args[0],
+			"name":               name,
+			"project_id":         projectId,
+			"number_examples":    count,
+			"generation_type":    generation_type,
+		}
+		if tone != "" {
+			body["generation_tone"] = tone
+		}
+		if lang != "" {
+			body["lang"] = lang
+		}
+		scenario := &ScenarioSet{}
+		if err := doOkareoRequest(apiKey, http.MethodPost, "/v0/scenario_sets_generate", body, scenario); err != nil {
+			printAPIError("generate scenario set '"+name+"'", err, isDebug)
+		}
+		printScenarioSummary(cmd, scenario)
+	},
+}
+
+var scenariosDownloadCmd = &cobra.Command{
+	Use:   "download <scenario-id>",
+	Short: "Download a scenario set to a local JSONL or CSV file",
+	Long:  `Downloads the data points of a scenario set. The file defaults to .okareo/scenarios/<name>.jsonl in the workspace; a .csv extension writes CSV instead.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		isDebug, _ := cmd.Flags().GetBool("debug")
+		apiKey := requireAPIKey(cmd)
+		// the default file is inside the workspace's .okareo; --file stays relative to where okareo was started
+		if err := enterWorkspace(cmd, true, "file"); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		out_file, _ := cmd.Flags().GetString("file")
+
+		scenario := get_scenario_set(apiKey, args[0], isDebug)
+		rows := get_scenario_data_points(apiKey, args[0], isDebug)
+		if out_file == "" {
+			out_file = "./.okareo/scenarios/" + safeFileName(scenario.Name) + ".jsonl"
+		}
+
+		seeds := make([]SeedData, 0, len(rows))
+		for _, row := range rows {
+			seeds = append(seeds, SeedData{Input: row.Input, Result: row.Result})
+		}
+		var content []byte
+		var err error
+		if strings.ToLower(filepath.Ext(out_file)) == ".csv" {
+			content, err = encodeCSV(seeds)
+		} else {
+			content, err = encodeJSONL(seeds)
+		}
+		check(err)
+
+		if err := os.MkdirAll(filepath.Dir(out_file), 0777); err != nil {
+			fmt.Println("Error: Unable to create the directory for '"+out_file+"'.", err)
+			os.Exit(1)
+		}
+		if err := os.WriteFile(out_file, content, 0644); err != nil {
+			fmt.Println("Error: Unable to write '"+out_file+"'.", err)
+			os.Exit(1)
+		}
+		fmt.Println("Downloaded", len(seeds), "rows from '"+scenario.Name+"' to", out_file)
+	},
+}
+
+func get_scenario_set(api_token string, scenario_id string, isDebug bool) *ScenarioSet {
+	scenarios := []ScenarioSet{}
+	err := doOkareoRequest(api_token, http.MethodGet, "/v0/scenario_sets?scenario_id="+url.QueryEscape(scenario_id), nil, &scenarios)
+	if err != nil {
+		printAPIError("get scenario set '"+scenario_id+"'", err, isDebug)
+	}
+	if len(scenarios) == 0 {
+		fmt.Println("Error: Scenario set '" + scenario_id + "' was not found.")
+		os.Exit(1)
+	}
+	return &scenarios[0]
+}
+
+func get_scenario_data_points(api_token string, scenario_id string, isDebug bool) []ScenarioDataPoint {
+	rows := []ScenarioDataPoint{}
+	err := doOkareoRequest(api_token, http.MethodGet, "/v0/scenario_data_points/"+url.PathEscape(scenario_id), nil, &rows)
+	if err != nil {
+		printAPIError("get data points for scenario set '"+scenario_id+"'", err, isDebug)
+	}
+	return rows
+}
+
+func printScenarioSummary(cmd *cobra.Command, scenario *ScenarioSet) {
+	if scenario.Warning != "" {
+		fmt.Fprintln(os.Stderr, "Warning:", scenario.Warning)
+	}
+	printOutput(cmd, scenario, func(w io.Writer) {
+		printRow(w, "ID:", scenario.ScenarioID)
+		printRow(w, "Name:", scenario.Name)
+		printRow(w, "Type:", scenario.Type)
+		printRow(w, "Rows:", fmt.Sprint(scenario.ScenarioCount))
+		printRow(w, "Link:", scenario.AppLink)
+	})
+}
+
+// readSeedData loads seed rows from a .jsonl, .json or .csv file.
+func readSeedData(path string) ([]SeedData, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	seeds := []SeedData{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		reader := csv.NewReader(bytes.NewReader(data))
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			return seeds, nil
+		}
+		input_col, result_col := -1, -1
+		for i, header := range records[0] {
+			switch strings.ToLower(strings.TrimSpace(header)) {
+			case "input":
+				input_col = i
+			case "result":
+				result_col = i
+			}
+		}
+		if input_col < 0 || result_col < 0 {
+			return nil, fmt.Errorf("CSV files need 'input' and 'result' header columns")
+		}
+		for _, record := range records[1:] {
+			seeds = append(seeds, SeedData{Input: parseCSVValue(record[input_col]), Result: parseCSVValue(record[result_col])})
+		}
+	case ".json":
+		if err := json.Unmarshal(data, &seeds); err != nil {
+			return nil, err
+		}
+	default:
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+		line_number := 0
+		for scanner.Scan() {
+			line_number++
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			seed := SeedData{}
+			if err := json.Unmarshal([]byte(line), &seed); err != nil {
+				return nil, fmt.Errorf("line %d: %v", line_number, err)
+			}
+			seeds = append(seeds, seed)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return seeds, nil
+}
+
+// parseCSVValue lets CSV cells hold JSON objects or arrays for structured inputs.
+func parseCSVValue(value string) interface{} {
+	trimmed := strings.TrimSpace(value)
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		var parsed interface{}
+		if err := json.Unmarshal([]byte(trimmed), &parsed); err == nil {
+			return parsed
+		}
+	}
+	return value
+}
+
+func encodeJSONL(seeds []SeedData) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, seed := range seeds {
+		line, err := json.Marshal(seed)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeCSV(seeds []SeedData) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	if err := writer.Write([]string{"input", "result"}); err != nil {
+		return nil, err
+	}
+	for _, seed := range seeds {
+		if err := writer.Write([]string{toText(seed.Input), toText(seed.Result)}); err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+// toText renders strings as-is and any structured value as compact JSON.
+func toText(value interface{}) string {
+	if text, ok := value.(string); ok {
+		return text
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// truncate shortens text to max characters for a table cell, counting runes so that
+// multi-byte characters are never split.
+func truncate(text string, max int) string {
+	text = strings.ReplaceAll(text, "\n", " ")
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max-3]) + "..."
+}
+
+func seedFileName(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func safeFileName(name string) string {
+	return strings.Trim(unsafeFileChars.ReplaceAllString(name, "_"), "_")
+}
+
+func init() {
+	rootCmd.AddCommand(scenariosCmd)
+	addOutputFlag(scenariosCmd)
+	scenariosCmd.PersistentFlags().BoolP("debug", "d", false, "See additional stdout to debug API calls.")
+
+	scenariosCmd.AddCommand(scenariosListCmd)
+	scenariosListCmd.Flags().StringP("project-id", "p", "", "The project name or ID to list scenario sets from. Defaults to OKAREO_PROJECT_ID or the project set with 'okareo projects use'.")
+	scenariosListCmd.Flags().StringP("name", "n", "", "Only show scenario sets whose name contains this text.")
+	scenariosListCmd.Flags().StringSliceP("tag", "t", nil, "Only show scenario sets with this tag. Repeat to require several tags.")
+
+	scenariosCmd.AddCommand(scenariosGetCmd)
+
+	scenariosCmd.AddCommand(scenariosCreateCmd)
+	scenariosCreateCmd.Flags().StringP("project-id", "p", "", "The project name or ID to create the scenario set in. Defaults to OKAREO_PROJECT_ID or the project set with 'okareo projects use'.")
+	scenariosCreateCmd.Flags().StringP("name", "n", "", "The scenario set name. Defaults to the file name.")
+	scenariosCreateCmd.Flags().StringSliceP("tag", "t", nil, "A tag to attach to the scenario set. Repeat for several tags.")
+
+	scenariosCmd.AddCommand(scenariosUploadCmd)
+	scenariosUploadCmd.Flags().StringP("project-id", "p", "", "The project name or ID to upload the scenario set to. Defaults to OKAREO_PROJECT_ID or the project set with 'okareo projects use'.")
+	scenariosUploadCmd.Flags().StringP("name", "n", "", "The scenario set name. Defaults to the file name.")
+
+	scenariosCmd.AddCommand(scenariosGenerateCmd)
+	scenariosGenerateCmd.Flags().StringP("project-id", "p", "", "The project name or ID to create the scenario set in. Defaults to OKAREO_PROJECT_ID or the project set with 'okareo projects use'.")
+	scenariosGenerateCmd.Flags().StringP("name", "n", "", "The name of the generated scenario set.")
+	scenariosGenerateCmd.Flags().IntP("count", "c", 1, "The number of examples to generate per seed row.")
+	scenariosGenerateCmd.Flags().String("type", "REPHRASE_INVARIANT", "The generation type, e.g. REPHRASE_INVARIANT, CONDITIONAL or TEXT_REVERSE_QUESTION.")
+	scenariosGenerateCmd.Flags().String("tone", "", "The generation tone, e.g. NEUTRAL, FORMAL or INFORMAL.")
+	scenariosGenerateCmd.Flags().String("lang", "", "The language of the generated examples.")
+
+	scenariosCmd.AddCommand(scenariosDownloadCmd)
+	scenariosDownloadCmd.Flags().StringP("file", "f", "", "Where to write the scenario set. Defaults to .okareo/scenarios/<name>.jsonl in the workspace.")
+}
@@ -0,0 +1,35 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		text string
+		max  int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly10!", 10, "exactly10!"},
+		{"a longer sentence", 10, "a longe..."},
+		{"two\nlines", 20, "two lines"},
+		{"héllo wörld", 11, "héllo wörld"},
+		{"héllo wörld again", 10, "héllo w..."},
+		{"日本語のテキストです", 6, "日本語..."},
+		{"emoji 😀😀😀😀", 9, "emoji ..."},
+	}
+	for _, tt := range tests {
+		got := truncate(tt.text, tt.max)
+		if got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.text, tt.max, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("truncate(%q, %d) = %q is not valid UTF-8", tt.text, tt.max, got)
+		}
+	}
+}
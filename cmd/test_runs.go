@@ -0,0 +1,280 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var testRunsCmd = &cobra.Command{
+	Use:     "test-runs",
+	Aliases: []string{"test-run", "testruns"},
+	Short:   "Browse Okareo test run results",
+	Long:    `List, inspect and delete test runs and their data points, including runs created by 'okareo run'.`,
+}
+
+var testRunsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List test runs in a project",
+	Run: func(cmd *cobra.Command, args []string) {
+		isDebug, _ := cmd.Flags().GetBool("debug")
+		projectFlag, _ := cmd.Flags().GetString("project-id")
+		model_id, _ := cmd.Flags().GetString("model-id")
+		scenario_id, _ := cmd.Flags().GetString("scenario-id")
+		tagFilter, _ := cmd.Flags().GetStringSlice("tag")
+		nameFilter, _ := cmd.Flags().GetString("name")
+		sinceFlag, _ := cmd.Flags().GetString("since")
+		untilFlag, _ := cmd.Flags().GetString("until")
+		limit, _ := cmd.Flags().GetInt("limit")
+
+		since, err := parseTimeFlag(sinceFlag, false)
+		if err != nil {
+			fmt.Println("Error: --since", err)
+			os.Exit(1)
+		}
+		until, err := parseTimeFlag(untilFlag, true)
+		if err != nil {
+			fmt.Println("Error: --until", err)
+			os.Exit(1)
+		}
+
+		apiKey := requireAPIKey(cmd)
+		projectId := resolveProjectID(apiKey, projectFlag)
+
+		query := url.Values{}
+		query.Set("project_id", projectId)
+		if model_id != "" {
+			query.Set("mut_id", model_id)
+		}
+		testruns := []TestRun{}
+		if err := doOkareoRequest(apiKey, http.MethodGet, "/v0/test_runs?"+query.Encode(), nil, &testruns); err != nil {
+			printAPIError("list test runs", err, isDebug)
+		}
+
+		filtered := []TestRun{}
+		for _, testrun := range testruns {
+			if model_id != "" && testrun.MutID != model_id {
+				continue
+			}
+			if scenario_id != "" && testrun.ScenarioSetID != scenario_id {
+				continue
+			}
+			if nameFilter != "" && !strings.Contains(strings.ToLower(testrun.Name), strings.ToLower(nameFilter)) {
+				continue
+			}
+			if !hasAllTags(testrun.Tags, tagFilter) {
+				continue
+			}
+			if !since.IsZero() || !until.IsZero() {
+				started, err := parseAPITime(testrun.StartTime)
+				if err != nil || (!since.IsZero() && started.Before(since)) || (!until.IsZero() && started.After(until)) {
+					continue
+				}
+			}
+			filtered = append(filtered, testrun)
+		}
+		// newest first, then trim to --limit
+		sort.SliceStable(filtered, func(i, j int) bool {
+			return filtered[i].StartTime > filtered[j].StartTime
+		})
+		if limit > 0 && len(filtered) > limit {
+			filtered = filtered[:limit]
+		}
+
+		printOutput(cmd, filtered, func(w io.Writer) {
+			printRow(w, "ID", "NAME", "TYPE", "MODEL", "SCENARIO", "ROWS", "STARTED", "TAGS")
+			for _, testrun := range filtered {
+				printRow(w, testrun.ID, testrun.Name, testrun.Type, testrun.MutID, testrun.ScenarioSetID, fmt.Sprint(testrun.TestDataPointCount), testrun.StartTime, strings.Join(testrun.Tags, ","))
+			}
+		})
+	},
+}
+
+var testRunsGetCmd = &cobra.Command{
+	Use:   "get <test-run-id>",
+	Short: "Show a test run with its metrics",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		isDebug, _ := cmd.Flags().GetBool("debug")
+		apiKey := requireAPIKey(cmd)
+
+		testrun := get_test_run(apiKey, args[0], isDebug)
+		printOutput(cmd, testrun, func(w io.Writer) {
+			printTestRunDetails(w, testrun)
+		})
+	},
+}
+
+var testRunsDatapointsCmd = &cobra.Command{
+	Use:   "datapoints <test-run-id>",
+	Short: "List the data points of a test run",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		isDebug, _ := cmd.Flags().GetBool("debug")
+		apiKey := requireAPIKey(cmd)
+
+		datapoints := []map[string]interface{}{}
+		body := map[string]interface{}{"test_run_id": args[0]}
+		if err := doOkareoRequest(apiKey, http.MethodPost, "/v0/find_test_data_points", body, &datapoints); err != nil {
+			printAPIError("get data points for test run '"+args[0]+"'", err, isDebug)
+		}
+		printOutput(cmd, datapoints, func(w io.Writer) {
+			printRow(w, "ID", "SCENARIO DATA POINT", "METRICS")
+			for _, datapoint := range datapoints {
+				printRow(w, toText(datapoint["id"]), toText(datapoint["scenario_data_point_id"]), truncate(toText(datapoint["metric_value"]), 80))
+			}
+		})
+	},
+}
+
+var testRunsDeleteCmd = &cobra.Command{
+	Use:   "delete <test-run-id>",
+	Short: "Delete a test run",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		isDebug, _ := cmd.Flags().GetBool("debug")
+		yes, _ := cmd.Flags().GetBool("yes")
+		apiKey := requireAPIKey(cmd)
+
+		testrun := get_test_run(apiKey, args[0], isDebug)
+		if !yes && !confirm("Delete test run '"+testrun.Name+"' ("+testrun.ID+")?") {
+			fmt.Println("Aborted.")
+			return
+		}
+		if err := doOkareoRequest(apiKey, http.MethodDelete, "/v0/test_runs/"+url.PathEscape(testrun.ID), nil, nil); err != nil {
+			printAPIError("delete test run '"+args[0]+"'", err, isDebug)
+		}
+		fmt.Println("Deleted test run: " + testrun.ID)
+	},
+}
+
+func get_test_run(api_token string, test_run_id string, isDebug bool) *TestRun {
+	testrun := &TestRun{}
+	if err := doOkareoRequest(api_token, http.MethodGet, "/v0/test_runs/"+url.PathEscape(test_run_id), nil, testrun); err != nil {
+		printAPIError("get test run '"+test_run_id+"'", err, isDebug)
+	}
+	return testrun
+}
+
+func printTestRunDetails(w io.Writer, testrun *TestRun) {
+	printRow(w, "ID:", testrun.ID)
+	printRow(w, "Name:", testrun.Name)
+	printRow(w, "Type:", testrun.Type)
+	printRow(w, "Project:", testrun.ProjectID)
+	printRow(w, "Model:", testrun.MutID)
+	printRow(w, "Scenario:", testrun.ScenarioSetID)
+	printRow(w, "Tags:", strings.Join(testrun.Tags, ","))
+	printRow(w, "Started:", testrun.StartTime)
+	printRow(w, "Ended:", testrun.EndTime)
+	printRow(w, "Rows:", fmt.Sprint(testrun.TestDataPointCount))
+	printRow(w, "Link:", testrun.AppLink)
+	if len(testrun.ModelMetrics) > 0 {
+		printRow(w, "")
+		printRow(w, "METRIC", "VALUE")
+		for _, metric := range flattenMetrics("", testrun.ModelMetrics) {
+			printRow(w, metric[0], metric[1])
+		}
+	}
+	if len(testrun.ErrorMatrix) > 0 {
+		printRow(w, "")
+		printRow(w, "ERROR MATRIX", "VALUE")
+		for _, metric := range flattenMetrics("", testrun.ErrorMatrix) {
+			printRow(w, metric[0], metric[1])
+		}
+	}
+}
+
+// flattenMetrics turns nested metric maps into sorted dotted name/value pairs.
+func flattenMetrics(prefix string, metrics map[string]interface{}) [][2]string {
+	rows := [][2]string{}
+	for key, value := range metrics {
+		name := key
+		if prefix != "" {
+			name = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			rows = append(rows, flattenMetrics(name, nested)...)
+			continue
+		}
+		rows = append(rows, [2]string{name, formatMetric(value)})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+	return rows
+}
+
+func formatMetric(value interface{}) string {
+	if number, ok := value.(float64); ok {
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	return truncate(toText(value), 80)
+}
+
+// parseTimeFlag accepts RFC3339 timestamps, YYYY-MM-DD dates and relative ages such as 24h or 7d.
+// A date is the start of that day (UTC), or its end when end_of_day is set, so that
+// --until 2024-05-01 includes the test runs of May 1.
+func parseTimeFlag(value string, end_of_day bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if strings.HasSuffix(value, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(value, "d")); err == nil {
+			return time.Now().AddDate(0, 0, -days), nil
+		}
+	}
+	if age, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-age), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		if end_of_day {
+			return t.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("expects a date (2006-01-02), an RFC3339 time or an age such as 24h or 7d: %s", value)
+}
+
+// parseAPITime parses timestamps returned by the API, which may omit the zone.
+func parseAPITime(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time: %s", value)
+}
+
+func init() {
+	rootCmd.AddCommand(testRunsCmd)
+	addOutputFlag(testRunsCmd)
+	testRunsCmd.PersistentFlags().BoolP("debug", "d", false, "See additional stdout to debug API calls.")
+
+	testRunsCmd.AddCommand(testRunsListCmd)
+	testRunsListCmd.Flags().StringP("project-id", "p", "", "The project name or ID to list test runs from. Defaults to OKAREO_PROJECT_ID or the project set with 'okareo projects use'.")
+	testRunsListCmd.Flags().StringP("model-id", "m", "", "Only show test runs of this model under test.")
+	testRunsListCmd.Flags().StringP("scenario-id", "s", "", "Only show test runs of this scenario set.")
+	testRunsListCmd.Flags().StringSliceP("tag", "t", nil, "Only show test runs with this tag. Repeat to require several tags.")
+	testRunsListCmd.Flags().StringP("name", "n", "", "Only show test runs whose name contains this text.")
+	testRunsListCmd.Flags().String("since", "", "Only show test runs started after this date, time or age (e.g. 2024-05-01, 7d).")
+	testRunsListCmd.Flags().String("until", "", "Only show test runs started up to this date (inclusive), time or age.")
+	testRunsListCmd.Flags().IntP("limit", "l", 0, "Show at most this many of the newest test runs.")
+
+	testRunsCmd.AddCommand(testRunsGetCmd)
+	testRunsCmd.AddCommand(testRunsDatapointsCmd)
+
+	testRunsCmd.AddCommand(testRunsDeleteCmd)
+	testRunsDeleteCmd.Flags().BoolP("yes", "y", false, "Delete without asking for confirmation.")
+}
@@ -0,0 +1,61 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeFlag(t *testing.T) {
+	tests := []struct {
+		value      string
+		end_of_day bool
+		want       time.Time
+		wantErr    bool
+	}{
+		{"", false, time.Time{}, false},
+		{"2024-05-01", false, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), false},
+		{"2024-05-01", true, time.Date(2024, 5, 1, 23, 59, 59, 999999999, time.UTC), false},
+		{"2024-05-01T10:30:00Z", false, time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC), false},
+		{"2024-05-01T10:30:00Z", true, time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC), false},
+		{"2024-05-01T12:30:00+02:00", false, time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC), false},
+		{"yesterday", false, time.Time{}, true},
+		{"2024-13-01", false, time.Time{}, true},
+		{"xd", false, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTimeFlag(tt.value, tt.end_of_day)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTimeFlag(%q, %v) error = %v, wantErr %v", tt.value, tt.end_of_day, err, tt.wantErr)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseTimeFlag(%q, %v) = %v, want %v", tt.value, tt.end_of_day, got, tt.want)
+		}
+	}
+}
+
+func TestParseTimeFlagAges(t *testing.T) {
+	tests := []struct {
+		value string
+		age   time.Duration
+	}{
+		{"24h", 24 * time.Hour},
+		{"90m", 90 * time.Minute},
+		{"7d", 7 * 24 * time.Hour},
+		{"0d", 0},
+	}
+	for _, tt := range tests {
+		got, err := parseTimeFlag(tt.value, true)
+		if err != nil {
+			t.Errorf("parseTimeFlag(%q) error = %v", tt.value, err)
+			continue
+		}
+		// ages are relative to now; days count calendar days, which DST can stretch by an hour
+		if diff := time.Since(got) - tt.age; diff < -time.Hour || diff > time.Hour {
+			t.Errorf("parseTimeFlag(%q) = %v, want about %v ago", tt.value, got, tt.age)
+		}
+	}
+}
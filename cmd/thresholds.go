@@ -0,0 +1,144 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Thresholds fail flows whose metrics miss a target. They are set per flow glob in
+// config.yml, for config flows and script flows alike:
+//
+//	run:
+//	  flows:
+//	    thresholds:
+//	      "retrieval/*":
+//	        accuracy: ">= 0.9"
+//	        latency_ms: "< 500"
+//	      nightly:
+//	        fluency: 4
+//
+// Metric names are dotted paths into the flow's metrics. Check scores reported under
+// mean_scores can be named directly, e.g. fluency. A bare number means ">=".
+type Threshold struct {
+	Metric string
+	Op     string
+	Value  float64
+}
+
+var thresholdPattern = regexp.MustCompile(`^(>=|<=|==|!=|>|<)?\s*(\S+)$`)
+
+func parseThreshold(metric string, condition string) (Threshold, error) {
+	match := thresholdPattern.FindStringSubmatch(strings.TrimSpace(condition))
+	if match == nil {
+		return Threshold{}, fmt.Errorf("'%s' is not a valid threshold for %s. Use a comparison such as '>= 0.9'", condition, metric)
+	}
+	value, err := strconv.ParseFloat(match[2], 64)
+	if err != nil {
+		return Threshold{}, fmt.Errorf("'%s' is not a valid threshold for %s. Use a comparison such as '>= 0.9'", condition, metric)
+	}
+	threshold := Threshold{Metric: metric, Op: match[1], Value: value}
+	if threshold.Op == "" {
+		threshold.Op = ">="
+	}
+	return threshold, nil
+}
+
+func (t Threshold) String() string {
+	return t.Metric + " " + t.Op + " " + strconv.FormatFloat(t.Value, 'f', -1, 64)
+}
+
+// Met reports whether a metric value meets the threshold.
+func (t Threshold) Met(value float64) bool {
+	switch t.Op {
+	case ">":
+		return value > t.Value
+	case "<":
+		return value < t.Value
+	case "<=":
+		return value <= t.Value
+	case "==":
+		return value == t.Value
+	case "!=":
+		return value != t.Value
+	}
+	return value >= t.Value
+}
+
+// flowThresholds returns the thresholds of the first glob matching a flow, by metric name.
+func flowThresholds(name string, thresholds FlowGlobs[map[string]string]) ([]Threshold, error) {
+	conditions, _ := thresholds.Lookup(name)
+	list := []Threshold{}
+	for metric, condition := range conditions {
+		threshold, err := parseThreshold(metric, condition)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, threshold)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Metric < list[j].Metric })
+	return list, nil
+}
+
+// checkThresholds adds an assertion for each threshold of a finished flow. A missed
+// threshold fails a flow that passed. Matrix cells use the thresholds of their flow.
+func checkThresholds(result *FlowResult, thresholds FlowGlobs[map[string]string]) {
+	name := result.Name
+	if result.Matrix != nil {
+		name = result.Matrix.Flow
+	}
+	list, _ := flowThresholds(name, thresholds)
+	metrics := result.Metrics
+	if len(metrics) == 0 && len(result.TestRuns) == 1 {
+		metrics = result.TestRuns[0].Metrics
+	}
+	// a flow that failed without reporting metrics has nothing to compare
+	if len(list) == 0 || (result.Status != flowPassed && (result.Status != flowFailed || len(metrics) == 0)) {
+		return
+	}
+	for _, threshold := range list {
+		assertion := FlowAssertion{Name: threshold.String()}
+		if value, ok := metricValue(metrics, threshold.Metric); ok {
+			assertion.Passed = threshold.Met(value)
+			assertion.Message = threshold.Metric + " is " + strconv.FormatFloat(value, 'f', -1, 64)
+		} else {
+			assertion.Message = "the flow did not report " + threshold.Metric
+		}
+		if !assertion.Passed {
+			fmt.Fprintln(runOutput, "Threshold missed: "+assertion.Name+": "+assertion.Message)
+		}
+		result.Assertions = append(result.Assertions, assertion)
+	}
+	failed := failedAssertions(result.Assertions)
+	if len(failed) > 0 && result.Status == flowPassed {
+		result.Status = flowFailed
+		result.Error = fmt.Sprintf("%d of %d assertions failed", len(failed), len(result.Assertions))
+	}
+}
+
+// metricValue looks up a numeric metric by its dotted path, falling back to mean_scores.
+func metricValue(metrics map[string]interface{}, name string) (float64, bool) {
+	for _, path := range []string{name, "mean_scores." + name} {
+		var value interface{} = metrics
+		for _, key := range strings.Split(path, ".") {
+			fields, ok := value.(map[string]interface{})
+			if !ok {
+				value = nil
+				break
+			}
+			value = fields[key]
+		}
+		switch number := value.(type) {
+		case float64:
+			return number, true
+		case int:
+			return float64(number), true
+		}
+	}
+	return 0, false
+}
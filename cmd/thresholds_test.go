@@ -0,0 +1,76 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import "testing"
+
+func TestThresholds(t *testing.T) {
+	metrics := map[string]interface{}{
+		"accuracy":    0.92,
+		"mean_scores": map[string]interface{}{"fluency": 4.0},
+	}
+	tests := []struct {
+		metric    string
+		condition string
+		met       bool
+		reported  bool
+		wantErr   bool
+	}{
+		{"accuracy", ">= 0.9", true, true, false},
+		{"accuracy", ">=0.95", false, true, false},
+		{"accuracy", "0.9", true, true, false},
+		{"accuracy", "< 0.9", false, true, false},
+		{"fluency", "> 3.5", true, true, false},
+		{"mean_scores.fluency", "== 4", true, true, false},
+		{"fluency", "!= 4", false, true, false},
+		{"recall", ">= 0.5", false, false, false},
+		{"accuracy", "=> 0.9", false, false, true},
+		{"accuracy", "high", false, false, true},
+	}
+	for _, tt := range tests {
+		threshold, err := parseThreshold(tt.metric, tt.condition)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseThreshold(%q, %q) error = %v, wantErr %v", tt.metric, tt.condition, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		value, reported := metricValue(metrics, tt.metric)
+		if reported != tt.reported {
+			t.Errorf("metricValue(%q) reported = %v, want %v", tt.metric, reported, tt.reported)
+		}
+		if reported && threshold.Met(value) != tt.met {
+			t.Errorf("%s met = %v for %v, want %v", threshold, !tt.met, value, tt.met)
+		}
+	}
+}
+
+func TestCheckThresholds(t *testing.T) {
+	thresholds := FlowGlobs[map[string]string]{{Pattern: "retrieval", Value: map[string]string{"accuracy": ">= 0.9"}}}
+	tests := []struct {
+		name       string
+		result     FlowResult
+		status     string
+		assertions int
+	}{
+		{"met", FlowResult{Name: "retrieval/search.py", Status: flowPassed, Metrics: map[string]interface{}{"accuracy": 0.95}}, flowPassed, 1},
+		{"missed", FlowResult{Name: "retrieval/search.py", Status: flowPassed, Metrics: map[string]interface{}{"accuracy": 0.5}}, flowFailed, 1},
+		{"not reported", FlowResult{Name: "retrieval/search.py", Status: flowPassed}, flowFailed, 1},
+		{"test run metrics", FlowResult{Name: "retrieval/search.py", Status: flowPassed, TestRuns: []FlowTestRun{{ID: "t1", Metrics: map[string]interface{}{"accuracy": 0.9}}}}, flowPassed, 1},
+		{"other flow", FlowResult{Name: "generation.py", Status: flowPassed}, flowPassed, 0},
+		{"failed without metrics", FlowResult{Name: "retrieval/search.py", Status: flowFailed}, flowFailed, 0},
+		{"timed out", FlowResult{Name: "retrieval/search.py", Status: flowTimedOut, Metrics: map[string]interface{}{"accuracy": 0.5}}, flowTimedOut, 0},
+		{"matrix cell", FlowResult{Name: "retrieval [m1]", Status: flowPassed, Metrics: map[string]interface{}{"accuracy": 0.5}, Matrix: &MatrixCell{Flow: "retrieval"}}, flowFailed, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.result
+			checkThresholds(&result, thresholds)
+			if result.Status != tt.status || len(result.Assertions) != tt.assertions {
+				t.Errorf("status %s with %d assertions, want %s with %d", result.Status, len(result.Assertions), tt.status, tt.assertions)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+var python_venv_dir string = "./.okareo/.venv"
+
+// the hash of the requirements last installed into the venv
+var python_venv_marker string = python_venv_dir + "/.okareo-requirements.sha256"
+
+var node_modules_dir string = "./.okareo/node_modules"
+
+// the hash of the package files last installed into node_modules
+var node_modules_marker string = node_modules_dir + "/.okareo-install.sha256"
+
+var npm_dependency_files = []string{"./.okareo/package.json", "./.okareo/package-lock.json"}
+
+// pythonInterpreter returns the interpreter inside the workspace venv.
+func pythonInterpreter() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(python_venv_dir, "Scripts", "python.exe")
+	}
+	return filepath.Join(python_venv_dir, "bin", "python")
+}
+
+func hasUV() bool {
+	_, err := exec.LookPath("uv")
+	return err == nil
+}
+
+// ensurePythonVenv creates .okareo/.venv with uv when available, otherwise with python3 -m venv.
+func ensurePythonVenv(debug bool) error {
+	if fileExists(pythonInterpreter()) {
+		return nil
+	}
+	var venv_cmd *exec.Cmd
+	if hasUV() {
+		if debug {
+			fmt.Fprintln(runOutput, "Debug: Creating virtual environment with uv.")
+		}
+		venv_cmd = exec.Command("uv", "venv", python_venv_dir)
+	} else {
+		if debug {
+			fmt.Fprintln(runOutput, "Debug: Creating virtual environment with python3 -m venv.")
+		}
+		venv_cmd = exec.Command("python3", "-m", "venv", python_venv_dir)
+	}
+	if err := runSetupCommand(venv_cmd, debug); err != nil {
+		return fmt.Errorf("unable to create the Python virtual environment in %s. Install uv or the python3-venv package: %v", python_venv_dir, err)
+	}
+	return nil
+}
+
+// pythonInstallCommand installs the requirements file into the venv.
+func pythonInstallCommand(req_file string) *exec.Cmd {
+	if hasUV() {
+		return exec.Command("uv", "pip", "install", "--python", pythonInterpreter(), "-r", req_file)
+	}
+	return exec.Command(pythonInterpreter(), "-m", "pip", "install", "-r", req_file)
+}
+
+// hashFiles returns a digest over the names and contents of the files that exist.
+func hashFiles(files ...string) string {
+	hash := sha256.New()
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		io.WriteString(hash, file+"\n")
+		hash.Write(data)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func readMarker(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// runSetupCommand runs an install step, streaming its stdout in debug mode and
+// surfacing stderr when it fails.
+func runSetupCommand(cmd *exec.Cmd, debug bool) error {
+	var stderr strings.Builder
+	if debug {
+		cmd.Stderr = os.Stderr
+	} else {
+		cmd.Stderr = &stderr
+	}
+	pipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(pipe)
+	line, err := reader.ReadString('\n')
+	for err == nil {
+		if debug {
+			fmt.Fprint(runOutput, line)
+		}
+		line, err = reader.ReadString('\n')
+	}
+	if err := cmd.Wait(); err != nil {
+		if stderr.Len() > 0 {
+			fmt.Fprint(runOutput, stderr.String())
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+)
+
+// how long file changes settle before `okareo run --watch` re-runs flows
+var watch_debounce = 300 * time.Millisecond
+
+// folders directly under .okareo the CLI writes to, which never trigger a re-run
+var watch_ignored = []string{"dist", "reports", "node_modules", ".venv", "okareo"}
+
+// the dependency files that re-run every flow of a runner when they change
+var watch_dependencies = map[string][]string{
+	"requirements.txt":  {"python"},
+	"package.json":      {"typescript", "javascript"},
+	"package-lock.json": {"typescript", "javascript"},
+	"tsconfig.json":     {"typescript"},
+	"go.mod":            {"go"},
+	"go.sum":            {"go"},
+}
+
+// watchRun runs the plan, then re-runs the flows affected by each change to
+// .okareo until Ctrl-C. Changes to the config file re-plan and re-run everything.
+func watchRun(run_ctx context.Context, cmd *cobra.Command, reporter *RunReporter, plan *RunPlan) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		reporter.Fatal("Error: Unable to watch for changes.", err)
+	}
+	defer watcher.Close()
+	config_file, _ := filepath.Abs(plan.ConfigFile)
+	if err := addWatchDirs(watcher, "./.okareo"); err != nil {
+		reporter.Fatal("Error: Unable to watch .okareo.", err)
+	}
+	// editors often replace files on save, so watch the config's folder rather than the file
+	if err := watcher.Add(filepath.Dir(config_file)); err != nil {
+		reporter.Fatal("Error: Unable to watch "+plan.ConfigFile+".", err)
+	}
+
+	latest := map[string]FlowResult{}
+	runAndReport := func(plan *RunPlan) {
+		results := executeRun(run_ctx, reporter, plan)
+		for _, result := range results {
+			latest[result.Name] = result
+		}
+		merged := []FlowResult{}
+		for _, result := range latest {
+			merged = append(merged, result)
+		}
+		sort.Slice(merged, func(i, j int) bool { return merged[i].Name < merged[j].Name })
+		if plan.Context.ReportsDirPath != "" && len(merged) > 0 {
+			if err := writeRunResults(plan.Context.ReportsDirPath, plan.Context.RunName, merged); err != nil {
+				fmt.Fprintln(runOutput, "Warning: Unable to write the run results.", err)
+			}
+		}
+		printWatchStatus(results, merged)
+	}
+	runAndReport(plan)
+
+	changed := map[string]bool{}
+	debounce := time.NewTimer(watch_debounce)
+	debounce.Stop()
+	for {
+		select {
+		case <-run_ctx.Done():
+			return
+		case err := <-watcher.Errors:
+			fmt.Fprintln(runOutput, "Warning: File watching error.", err)
+		case event := <-watcher.Events:
+			if event.Op == fsnotify.Chmod || isIgnoredWatchPath(event.Name) {
+				continue
+			}
+			if event.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() && !skipFlowDir(info.Name()) {
+					addWatchDirs(watcher, event.Name)
+				}
+			}
+			path, _ := filepath.Abs(event.Name)
+			changed[path] = true
+			debounce.Reset(watch_debounce)
+		case <-debounce.C:
+			paths := changed
+			changed = map[string]bool{}
+			// a broken config or build fails this re-run; watching goes on until it is fixed
+			if paths[config_file] {
+				fmt.Fprintln(runOutput, "Config changed. Re-running all flows.")
+				next, err := planRun(cmd)
+				if err != nil {
+					fmt.Fprintln(runOutput, "Error:", err)
+					fmt.Fprintln(runOutput, "Watching for changes, Ctrl-C to stop.")
+					continue
+				}
+				if next != nil {
+					plan = next
+					runAndReport(plan)
+				}
+				continue
+			}
+			affected, err := affectedPlan(plan, paths)
+			if err != nil {
+				fmt.Fprintln(runOutput, "Error:", err)
+				fmt.Fprintln(runOutput, "Watching for changes, Ctrl-C to stop.")
+				continue
+			}
+			if len(affected.Flows) == 0 {
+				continue
+			}
+			runAndReport(affected)
+		}
+	}
+}
+
+// affectedPlan returns a copy of the plan narrowed to the script flows touched by the
+// changed paths, with a run name of its own. Flows are rediscovered so new files are
+// picked up. A changed file that isn't a selected flow (e.g. a helper module) re-runs
+// every flow of its runner, as does a dependency file.
+func affectedPlan(plan *RunPlan, paths map[string]bool) (*RunPlan, error) {
+	flows, err := selectFlowFiles(plan)
+	if err != nil {
+		return nil, err
+	}
+	runners := map[string]bool{}
+	files := map[string]bool{}
+	for path := range paths {
+		matched := false
+		for _, flow := range flows {
+			if flow_path, _ := filepath.Abs(flow.Path); flow_path == path {
+				files[flow.Path] = true
+				matched = true
+			}
+		}
+		if matched {
+			continue
+		}
+		if languages, ok := watch_dependencies[filepath.Base(path)]; ok {
+			for _, language := range languages {
+				runners[language] = true
+			}
+			continue
+		}
+		if language := detectFlowLanguage(filepath.Base(path), plan.Config.Run.Flows.Languages, plan.Runners); language != "" {
+			runners[language] = true
+		}
+	}
+
+	affected := *plan
+	affected.ConfigFlows = nil
+	affected.Flows = []FlowFile{}
+	for _, flow := range flows {
+		if files[flow.Path] || runners[flow.Language] {
+			affected.Flows = append(affected.Flows, flow)
+		}
+	}
+	if err := checkOutputFile(plan.Context, affected.Flows); err != nil {
+		return nil, err
+	}
+	// each re-run is a run of its own in Okareo
+	ctx := *plan.Context
+	ctx.RunName, err = runName(plan.Config.RunName, plan.Config.Name, ctx.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("invalid run-name template: %v", err)
+	}
+	affected.Context = &ctx
+	return &affected, nil
+}
+
+// addWatchDirs watches dir and its sub folders. fsnotify doesn't watch recursively.
+func addWatchDirs(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return err
+		}
+		if path != dir && (isIgnoredWatchPath(path) || skipFlowDir(entry.Name())) {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+}
+
+// isIgnoredWatchPath reports whether path is inside one of the watch_ignored folders of
+// .okareo, or a folder such as node_modules or __pycache__ that flow discovery skips.
+func isIgnoredWatchPath(path string) bool {
+	okareo_dir, _ := filepath.Abs("./.okareo")
+	abs_path, _ := filepath.Abs(path)
+	rel, err := filepath.Rel(okareo_dir, abs_path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for _, ignored := range watch_ignored {
+		if parts[0] == ignored {
+			return true
+		}
+	}
+	for _, part := range parts[:len(parts)-1] {
+		if skipFlowDir(part) {
+			return true
+		}
+	}
+	return false
+}
+
+// printWatchStatus prints one line about the last re-run and the state of every flow.
+func printWatchStatus(results []FlowResult, all []FlowResult) {
+	failing := []string{}
+	for _, result := range all {
+		if result.Status != flowPassed {
+			failing = append(failing, result.Name+" "+result.Status)
+		}
+	}
+	status := fmt.Sprintf("[%s] ran %d flows, %d of %d passing", time.Now().Format("15:04:05"), len(results), len(all)-len(failing), len(all))
+	if len(failing) > 0 {
+		status += " (" + strings.Join(failing, ", ") + ")"
+	}
+	fmt.Fprintln(runOutput, status+". Watching for changes, Ctrl-C to stop.")
+}
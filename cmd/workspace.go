@@ -0,0 +1,234 @@
+/*
+Copyright © 2024 OKAREO oss@okareo.com
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+// enterWorkspace makes the workspace of a command the working directory, so the
+// ./.okareo paths used throughout the CLI resolve inside it. The workspace is the
+// --workspace folder or, when discover is set, the nearest folder with a .okareo
+// directory looking upward from the working directory. Relative paths given in
+// path_flags, --ca-bundle and OKAREO_CA_BUNDLE stay relative to where okareo was started.
+func enterWorkspace(cmd *cobra.Command, discover bool, path_flags ...string) error {
+	workspace, _ := cmd.Flags().GetString("workspace")
+	if workspace == "" && discover {
+		workspace = findWorkspaceUp()
+		if workspace != "" {
+			fmt.Fprintln(runOutput, "Using workspace "+workspace)
+		}
+	}
+	if workspace == "" {
+		return nil
+	}
+	if !dirExists(workspace) {
+		return fmt.Errorf("the workspace %s does not exist", workspace)
+	}
+	for _, name := range path_flags {
+		value, _ := cmd.Flags().GetString(name)
+		if cmd.Flags().Changed(name) && value != "" {
+			cmd.Flags().Set(name, absPath(value))
+		}
+	}
+	absCABundle()
+	if err := os.Chdir(workspace); err != nil {
+		return fmt.Errorf("unable to use the workspace %s: %v", workspace, err)
+	}
+	return nil
+}
+
+// absCABundle makes --ca-bundle and OKAREO_CA_BUNDLE absolute before changing to a workspace.
+func absCABundle() {
+	if ca_bundle, _ := rootCmd.PersistentFlags().GetString("ca-bundle"); ca_bundle != "" {
+		rootCmd.PersistentFlags().Set("ca-bundle", absPath(ca_bundle))
+	}
+	if ca_bundle := os.Getenv("OKAREO_CA_BUNDLE"); ca_bundle != "" {
+		os.Setenv("OKAREO_CA_BUNDLE", absPath(ca_bundle))
+	}
+}
+
+// absPath makes a path absolute against the working directory.
+func absPath(value string) string {
+	if filepath.IsAbs(value) {
+		return value
+	}
+	abs_value, err := filepath.Abs(value)
+	check(err)
+	return abs_value
+}
+
+// findWorkspaceUp returns the nearest folder above the working directory that has a
+// .okareo directory, or "" when the working directory has one itself or none is found.
+func findWorkspaceUp() string {
+	dir, err := os.Getwd()
+	if err != nil || dirExists(filepath.Join(dir, ".okareo")) {
+		return ""
+	}
+	for {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+		if dirExists(filepath.Join(dir, ".okareo")) {
+			return dir
+		}
+	}
+}
+
+// workspacesRoot is where `okareo run --all-workspaces` looks for workspaces: the
+// --workspace folder, else the root of the git repository, else the working directory.
+func workspacesRoot(cmd *cobra.Command) string {
+	root, _ := cmd.Flags().GetString("workspace")
+	if root == "" {
+		root = gitOutput("rev-parse", "--show-toplevel")
+	}
+	if root == "" {
+		root = "."
+	}
+	abs_root, err := filepath.Abs(root)
+	check(err)
+	return abs_root
+}
+
+// findWorkspaces returns every folder under root with a .okareo/config.yml. Folders
+// that can't be read are skipped.
+func findWorkspaces(root string) ([]string, error) {
+	workspaces := []string{}
+	err := filepath.WalkDir(root, func(dir string, e fs.DirEntry, err error) error {
+		if err != nil {
+			if dir == root {
+				return err
+			}
+			if e != nil && e.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !e.IsDir() {
+			return nil
+		}
+		if e.Name() == ".okareo" {
+			if fileExists(filepath.Join(dir, "config.yml")) {
+				workspaces = append(workspaces, filepath.Dir(dir))
+			}
+			return filepath.SkipDir
+		}
+		if dir != root && (e.Name() == "node_modules" || strings.HasPrefix(e.Name(), ".")) {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return workspaces, err
+}
+
+// runAllWorkspaces runs every workspace under the workspaces root one after another
+// and prints a combined summary, grouped by workspace. A workspace that can't be
+// planned fails in the summary and the remaining workspaces still run.
+func runAllWorkspaces(cmd *cobra.Command, reporter *RunReporter) {
+	isDebug, _ := cmd.Flags().GetBool("debug")
+	listFlows, _ := cmd.Flags().GetBool("list")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	reports_dir_path, _ := cmd.Flags().GetString("reports")
+	for _, name := range []string{"config", "outputFile", "watch"} {
+		if cmd.Flags().Changed(name) {
+			reporter.Fatal("Error: --"+name+" can't be combined with --all-workspaces.", nil)
+		}
+	}
+	if filepath.IsAbs(reports_dir_path) {
+		reporter.Fatal("Error: --reports must be relative to .okareo with --all-workspaces.", nil)
+	}
+
+	absCABundle()
+	root := workspacesRoot(cmd)
+	workspaces, err := findWorkspaces(root)
+	if err != nil {
+		reporter.Fatal("Error: Unable to search "+root+" for workspaces.", err)
+	}
+	if len(workspaces) == 0 {
+		fmt.Fprintln(runOutput, "No workspaces found under "+root+".")
+		return
+	}
+
+	// Ctrl-C stops the running flow and skips the remaining flows and workspaces
+	run_ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	reporter.Hold = true
+	all := []FlowResult{}
+	dry_run_failed := 0
+	for _, workspace := range workspaces {
+		if run_ctx.Err() != nil {
+			break
+		}
+		name, _ := filepath.Rel(root, workspace)
+		if name == "." {
+			name = filepath.Base(root)
+		}
+		name = filepath.ToSlash(name)
+		fmt.Fprintln(runOutput, "Workspace: "+name)
+		reporter.Workspace = name
+		var plan *RunPlan
+		err := os.Chdir(workspace)
+		if err == nil {
+			plan, err = planRun(cmd)
+		}
+		if err != nil {
+			fmt.Fprintln(runOutput, "Error: Unable to run the workspace "+name+".", err)
+			result := FlowResult{Name: name, Group: name, Runner: "workspace", Status: flowFailed, Error: err.Error()}
+			reporter.FlowFinished(result)
+			all = append(all, result)
+			continue
+		}
+		if plan == nil {
+			continue
+		}
+		if listFlows {
+			printFlowList(plan.ConfigFlows, plan.Flows)
+			continue
+		}
+		if dryRun {
+			dry_run_failed += printDryRun(plan.Context, plan.Config, plan.ConfigFlows, plan.Flows, plan.Runners, plan.Timeout, plan.Retries)
+			continue
+		}
+
+		if err := prepare_reports_dir(plan.Context.ReportsDirPath, isDebug); err != nil {
+			reporter.Fatal("Error: Unable to create the reports folder.", err)
+		}
+		results := executeRun(run_ctx, reporter, plan)
+		if plan.Context.ReportsDirPath != "" && len(results) > 0 {
+			if err := writeRunResults(plan.Context.ReportsDirPath, plan.Context.RunName, results); err != nil {
+				fmt.Fprintln(runOutput, "Warning: Unable to write the run results.", err)
+			}
+		}
+		printMatrixComparison(results)
+		for _, result := range results {
+			result.Name = path.Join(name, result.Name)
+			result.Group = name
+			all = append(all, result)
+		}
+	}
+	reporter.Flush()
+
+	if len(all) > 0 && !printRunSummary(all) {
+		if run_ctx.Err() != nil {
+			os.Exit(130)
+		}
+		os.Exit(1)
+	}
+	if dry_run_failed > 0 {
+		os.Exit(1)
+	}
+}